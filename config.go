@@ -11,18 +11,30 @@ import (
 // The SqliteDriver value comes from modernc.org/sqlite/sqlite.driverName
 const SqliteDriver = "sqlite"
 
+const (
+	MemorySessionStore   = "memory"
+	DatabaseSessionStore = "database"
+)
+
 type Config struct {
-	DbDriver           string
-	DbConnectionString string
-	Port               int
-	SessionLifetime    int
-	MailHost           string
-	MailPort           int
-	MailLogin          string
-	MailPassword       string
-	MailFrom           string
-	AddCorsHeader      bool
-	CorsWhitelist      string
+	DbDriver             string
+	DbConnectionString   string
+	AutoMigrate          bool
+	Port                 int
+	SessionLifetime      int
+	SessionStore         string
+	UndoWindow           int
+	MailHost             string
+	MailPort             int
+	MailLogin            string
+	MailPassword         string
+	MailFrom             string
+	AddCorsHeader        bool
+	CorsWhitelist        string
+	ResetVerifiedOnly    bool
+	AlphanumericBarcodes bool
+	RestockMails         []string
+	EventWebhook         string
 }
 
 var config Config
@@ -59,6 +71,16 @@ func mkconf() Config {
 		}
 	}
 
+	autoMigrate := true
+	autoMigrateString, exists := os.LookupEnv("GODRINK_AUTOMIGRATE")
+	if exists {
+		autoMigrate, err = strconv.ParseBool(autoMigrateString)
+		if err != nil {
+			autoMigrate = true
+			log.Println("Error parsing automatic migration flag from env, defaulting to true:", err)
+		}
+	}
+
 	lifetime := 300
 	lifetimeString, exists := os.LookupEnv("GODRINK_SESSIONLIFETIME")
 	if exists {
@@ -68,6 +90,32 @@ func mkconf() Config {
 			log.Println(fmt.Sprintf("Error parsing session lifetime from env, defaulting to %d:", lifetime), err)
 		}
 	}
+	sessionStore, exists := os.LookupEnv("GODRINK_SESSIONSTORE")
+	sessionStore = strings.ToLower(sessionStore)
+	if !exists {
+		sessionStore = MemorySessionStore
+	} else if sessionStore != MemorySessionStore && sessionStore != DatabaseSessionStore {
+		log.Printf("Unknown session store (%s), defaulting to %s.\n", sessionStore, MemorySessionStore)
+		sessionStore = MemorySessionStore
+	}
+	undoWindow := 60
+	undoWindowString, exists := os.LookupEnv("GODRINK_UNDOWINDOW")
+	if exists {
+		undoWindow, err = strconv.Atoi(undoWindowString)
+		if err != nil {
+			undoWindow = 60
+			log.Println(fmt.Sprintf("Error parsing undo window from env, defaulting to %d:", undoWindow), err)
+		}
+	}
+	alphanumericBarcodes := false
+	alphanumericBarcodesString, exists := os.LookupEnv("GODRINK_ALPHANUMERICBARCODES")
+	if exists {
+		alphanumericBarcodes, err = strconv.ParseBool(alphanumericBarcodesString)
+		if err != nil {
+			alphanumericBarcodes = false
+			log.Println("Error parsing alphanumeric barcodes flag from env, defaulting to false:", err)
+		}
+	}
 	smtpserver, exists := os.LookupEnv("GODRINK_SMTPHOST")
 	var mailHost string
 	mailPort := 465
@@ -97,19 +145,42 @@ func mkconf() Config {
 	if !exists {
 		mailFrom = mailLogin
 	}
+	resetVerifiedOnly := true
+	resetVerifiedOnlyString, exists := os.LookupEnv("GODRINK_RESETVERIFIEDONLY")
+	if exists {
+		resetVerifiedOnly, err = strconv.ParseBool(resetVerifiedOnlyString)
+		if err != nil {
+			resetVerifiedOnly = true
+			log.Println("Error parsing verified only password reset flag from env, defaulting to true:", err)
+		}
+	}
 	cors, addCorsHeader := os.LookupEnv("GODRINK_CORS")
+	var restockMails []string
+	for _, address := range strings.Split(os.Getenv("GODRINK_RESTOCKMAILS"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			restockMails = append(restockMails, address)
+		}
+	}
+	eventWebhook := os.Getenv("GODRINK_EVENTWEBHOOK")
 
 	return Config{
-		DbDriver:           dbdriver,
-		DbConnectionString: dbUrl,
-		Port:               port,
-		SessionLifetime:    lifetime,
-		MailHost:           mailHost,
-		MailPort:           mailPort,
-		MailLogin:          mailLogin,
-		MailPassword:       mailPass,
-		MailFrom:           mailFrom,
-		AddCorsHeader:      addCorsHeader,
-		CorsWhitelist:      cors,
+		DbDriver:             dbdriver,
+		DbConnectionString:   dbUrl,
+		AutoMigrate:          autoMigrate,
+		Port:                 port,
+		SessionLifetime:      lifetime,
+		SessionStore:         sessionStore,
+		UndoWindow:           undoWindow,
+		MailHost:             mailHost,
+		MailPort:             mailPort,
+		MailLogin:            mailLogin,
+		MailPassword:         mailPass,
+		MailFrom:             mailFrom,
+		AddCorsHeader:        addCorsHeader,
+		CorsWhitelist:        cors,
+		ResetVerifiedOnly:    resetVerifiedOnly,
+		AlphanumericBarcodes: alphanumericBarcodes,
+		RestockMails:         restockMails,
+		EventWebhook:         eventWebhook,
 	}
 }
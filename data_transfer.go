@@ -4,14 +4,22 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/pricing"
+	"github.com/Port39/go-drink/stock"
+	"github.com/Port39/go-drink/transactions"
 	"github.com/Port39/go-drink/users"
 	"github.com/google/uuid"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 var (
 	UsernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,64}$`)
 	EmailRegex    = regexp.MustCompile(`^[^@ \t\r\n]+@[^@ \t\r\n]+\.[^@ \t\r\n]+$`)
+	RoleRegex     = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,15}$`)
 )
 
 type passwordRegistrationRequest struct {
@@ -60,12 +68,23 @@ type loginResponse struct {
 	ValidUntil int64  `json:"validUntil"`
 }
 
+// profileResponse
+// The logged-in user, together with the new email which is waiting to be confirmed
+type profileResponse struct {
+	users.User
+	PendingEmail string `json:"pendingEmail,omitempty"`
+}
+
 type addItemRequest struct {
-	Name    string `json:"name"`
-	Price   int    `json:"price"`
-	Image   string `json:"image"`
-	Amount  int    `json:"amount"`
-	Barcode string `json:"barcode"`
+	Name       string   `json:"name"`
+	Price      int      `json:"price"`
+	Image      string   `json:"image"`
+	Amount     int      `json:"amount"`
+	Barcode    string   `json:"barcode"`
+	CategoryId string   `json:"categoryId"`
+	Tags       []string `json:"tags"`
+	MinStock   int      `json:"minStock"`
+	Deposit    int      `json:"deposit"`
 }
 
 func (r *addItemRequest) Validate() error {
@@ -79,19 +98,106 @@ func (r *addItemRequest) Validate() error {
 	if len(data) > 2097152 {
 		return errors.New("image to large (max 2MiB allowed)")
 	}
+	if err = items.CheckImageSize(data); err != nil {
+		return err
+	}
 	if r.Amount < 0 {
 		return errors.New("amount must not be negative")
 	}
-	return nil
+	if r.MinStock < 0 {
+		return errors.New("minimum stock must not be negative")
+	}
+	if r.Deposit < 0 {
+		return errors.New("deposit must not be negative")
+	}
+	if r.Barcode != "" {
+		r.Barcode, err = items.NormalizeBarcode(r.Barcode, config.AlphanumericBarcodes)
+		if err != nil {
+			return err
+		}
+	}
+	r.CategoryId, err = normalizeOptionalId(r.CategoryId)
+	if err != nil {
+		return err
+	}
+	r.Tags, err = normalizeTags(r.Tags)
+	return err
+}
+
+// ImageData
+// The uploaded image, Validate ensures that it is valid base64
+func (r *addItemRequest) ImageData() []byte {
+	data, _ := base64.StdEncoding.DecodeString(r.Image)
+	return data
+}
+
+// Barcodes
+// The barcode of a single unit of the item, if one is given
+func (r *addItemRequest) Barcodes() []items.Barcode {
+	return singleUnitBarcode(r.Barcode)
 }
 
 type updateItemRequest struct {
-	Id      string `json:"id"`
-	Name    string `json:"name"`
-	Price   int    `json:"price"`
-	Image   string `json:"image"`
-	Amount  int    `json:"amount"`
-	Barcode string `json:"barcode"`
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Price       int      `json:"price"`
+	Image       string   `json:"image"`
+	RemoveImage bool     `json:"removeImage"`
+	Amount      int      `json:"amount"`
+	Barcode     string   `json:"barcode"`
+	CategoryId  string   `json:"categoryId"`
+	Tags        []string `json:"tags"`
+	MinStock    int      `json:"minStock"`
+	Deposit     int      `json:"deposit"`
+}
+
+// ImageData
+// The uploaded image, Validate ensures that it is valid base64
+func (r *updateItemRequest) ImageData() []byte {
+	data, _ := base64.StdEncoding.DecodeString(r.Image)
+	return data
+}
+
+// Barcodes
+// The barcode of a single unit of the item, if one is given. It is added to the existing barcodes of the item.
+func (r *updateItemRequest) Barcodes() []items.Barcode {
+	return singleUnitBarcode(r.Barcode)
+}
+
+func singleUnitBarcode(code string) []items.Barcode {
+	if code == "" {
+		return nil
+	}
+	return []items.Barcode{{Code: code, Multiplier: 1}}
+}
+
+type addBarcodeRequest struct {
+	Barcode    string `json:"barcode"`
+	Multiplier int    `json:"multiplier"`
+}
+
+const maxBarcodeMultiplier = 1000
+
+func (r *addBarcodeRequest) Validate() error {
+	var err error
+	r.Barcode, err = items.NormalizeBarcode(r.Barcode, config.AlphanumericBarcodes)
+	if err != nil {
+		return err
+	}
+	if r.Multiplier == 0 {
+		r.Multiplier = 1
+	}
+	if r.Multiplier < 1 || r.Multiplier > maxBarcodeMultiplier {
+		return errors.New("multiplier must be between 1 and " + strconv.Itoa(maxBarcodeMultiplier))
+	}
+	return nil
+}
+
+// scannedItemResponse
+// The item of a scanned barcode, with the number of units the barcode stands for
+type scannedItemResponse struct {
+	items.Item
+	Multiplier int `json:"multiplier"`
 }
 
 func (r *updateItemRequest) Validate() error {
@@ -111,29 +217,420 @@ func (r *updateItemRequest) Validate() error {
 	if len(data) > 2097152 {
 		return errors.New("image to large (max 2MiB allowed)")
 	}
+	if err = items.CheckImageSize(data); err != nil {
+		return err
+	}
+	if len(data) > 0 && r.RemoveImage {
+		return errors.New("either upload an image or remove it, not both")
+	}
 	if r.Amount < 0 {
 		return errors.New("amount must not be negative")
 	}
+	if r.MinStock < 0 {
+		return errors.New("minimum stock must not be negative")
+	}
+	if r.Deposit < 0 {
+		return errors.New("deposit must not be negative")
+	}
+	if r.Barcode != "" {
+		r.Barcode, err = items.NormalizeBarcode(r.Barcode, config.AlphanumericBarcodes)
+		if err != nil {
+			return err
+		}
+	}
+	r.CategoryId, err = normalizeOptionalId(r.CategoryId)
+	if err != nil {
+		return err
+	}
+	r.Tags, err = normalizeTags(r.Tags)
+	return err
+}
+
+const (
+	maxTags      = 16
+	maxTagLength = 32
+)
+
+// normalizeTags
+// Tags are compared case-insensitively, so they are kept in lower case and without duplicates
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, errors.New("too many tags")
+	}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength {
+			return nil, errors.New("tags must not be empty or longer than 32 bytes")
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+func normalizeOptionalId(id string) (string, error) {
+	if id == "" {
+		return "", nil
+	}
+	parsed, err := uuid.Parse(id)
+	return parsed.String(), err
+}
+
+type addCategoryRequest struct {
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+}
+
+func (r *addCategoryRequest) Validate() error {
+	return validateCategory(r.Name, r.Position)
+}
+
+type updateCategoryRequest struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+}
+
+func (r *updateCategoryRequest) Validate() error {
+	id, err := uuid.Parse(r.Id)
+	if err != nil {
+		return err
+	}
+	r.Id = id.String()
+	return validateCategory(r.Name, r.Position)
+}
+
+func validateCategory(name string, position int) error {
+	if name == "" || len(name) > 64 {
+		return errors.New("name must not be empty or longer than 64 bytes")
+	}
+	if position < 0 || position > items.MaxCategoryPosition {
+		return errors.New("position must be between 0 and " + strconv.Itoa(items.MaxCategoryPosition))
+	}
 	return nil
 }
 
-type buyItemRequest struct {
+type restockRequest struct {
+	Amount   int    `json:"amount"`
+	UnitCost int    `json:"unitCost"`
+	Supplier string `json:"supplier"`
+}
+
+func (r *restockRequest) Validate() error {
+	if r.Amount < 1 {
+		return errors.New("amount must be at least one item")
+	}
+	if r.UnitCost < 0 {
+		return errors.New("unit cost must not be negative")
+	}
+	return validateSupplier(r.Supplier)
+}
+
+type deliveryLine struct {
+	ItemId   string `json:"itemId"`
+	Amount   int    `json:"amount"`
+	UnitCost int    `json:"unitCost"`
+}
+
+func (l *deliveryLine) Validate() error {
+	id, err := uuid.Parse(l.ItemId)
+	if err != nil {
+		return err
+	}
+	l.ItemId = id.String()
+	if l.Amount < 1 {
+		return errors.New("amount must be at least one item")
+	}
+	if l.UnitCost < 0 {
+		return errors.New("unit cost must not be negative")
+	}
+	return nil
+}
+
+const maxDeliveryLines = 64
+
+// restockDeliveryRequest
+// All items of a delivery from a single supplier
+type restockDeliveryRequest struct {
+	Supplier string         `json:"supplier"`
+	Items    []deliveryLine `json:"items"`
+}
+
+func (r *restockDeliveryRequest) Validate() error {
+	if len(r.Items) == 0 {
+		return errors.New("the delivery contains no items")
+	}
+	if len(r.Items) > maxDeliveryLines {
+		return errors.New("too many items in the delivery")
+	}
+	for i := range r.Items {
+		err := r.Items[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return validateSupplier(r.Supplier)
+}
+
+func (r *restockDeliveryRequest) Delivery() stock.Delivery {
+	lines := make([]stock.DeliveryLine, 0, len(r.Items))
+	for _, item := range r.Items {
+		lines = append(lines, stock.DeliveryLine{ItemId: item.ItemId, Amount: item.Amount, UnitCost: item.UnitCost})
+	}
+	return stock.Delivery{Supplier: r.Supplier, Lines: lines}
+}
+
+func validateSupplier(supplier string) error {
+	if len(supplier) > 64 {
+		return errors.New("supplier must not be longer than 64 bytes")
+	}
+	return nil
+}
+
+type countedItem struct {
 	ItemId string `json:"itemId"`
 	Amount int    `json:"amount"`
 }
 
-func (r *buyItemRequest) Validate() error {
-	id, err := uuid.Parse(r.ItemId)
+const maxStocktakeLines = 1000
+
+// stocktakeRequest
+// The counted amounts of all items found while taking stock
+type stocktakeRequest struct {
+	Items []countedItem `json:"items"`
+}
+
+func (r *stocktakeRequest) Validate() error {
+	if len(r.Items) == 0 {
+		return errors.New("no items have been counted")
+	}
+	if len(r.Items) > maxStocktakeLines {
+		return errors.New("too many items in the stocktake")
+	}
+	seen := make(map[string]bool, len(r.Items))
+	for i := range r.Items {
+		id, err := uuid.Parse(r.Items[i].ItemId)
+		if err != nil {
+			return err
+		}
+		r.Items[i].ItemId = id.String()
+		if seen[r.Items[i].ItemId] {
+			return errors.New("every item must only be counted once")
+		}
+		seen[r.Items[i].ItemId] = true
+		if r.Items[i].Amount < 0 {
+			return errors.New("amount must not be negative")
+		}
+	}
+	return nil
+}
+
+func (r *stocktakeRequest) CountedItems() []stock.CountedItem {
+	counted := make([]stock.CountedItem, 0, len(r.Items))
+	for _, item := range r.Items {
+		counted = append(counted, stock.CountedItem{ItemId: item.ItemId, Amount: item.Amount})
+	}
+	return counted
+}
+
+type cartLine struct {
+	ItemId string `json:"itemId"`
+	Amount int    `json:"amount"`
+}
+
+func (l *cartLine) Validate() error {
+	id, err := uuid.Parse(l.ItemId)
 	if err != nil {
 		return err
 	}
-	r.ItemId = id.String()
-	if r.Amount < 1 {
+	l.ItemId = id.String()
+	if l.Amount < 1 {
 		return errors.New("amount must be at least one item")
 	}
 	return nil
 }
 
+const maxCartLines = 64
+
+// buyItemRequest
+// either contains a single item (itemId and amount), or a whole cart of items
+type buyItemRequest struct {
+	ItemId string     `json:"itemId"`
+	Amount int        `json:"amount"`
+	Items  []cartLine `json:"items"`
+}
+
+func (r *buyItemRequest) Validate() error {
+	if len(r.Items) == 0 {
+		line := cartLine{ItemId: r.ItemId, Amount: r.Amount}
+		err := line.Validate()
+		r.ItemId = line.ItemId
+		return err
+	}
+	if r.ItemId != "" {
+		return errors.New("either itemId or items must be given, not both")
+	}
+	if len(r.Items) > maxCartLines {
+		return errors.New("too many items in the cart")
+	}
+	for i := range r.Items {
+		err := r.Items[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *buyItemRequest) CartLines() []transactions.CartLine {
+	if len(r.Items) == 0 {
+		return []transactions.CartLine{{ItemId: r.ItemId, Amount: r.Amount}}
+	}
+	lines := make([]transactions.CartLine, 0, len(r.Items))
+	for _, item := range r.Items {
+		lines = append(lines, transactions.CartLine{ItemId: item.ItemId, Amount: item.Amount})
+	}
+	return lines
+}
+
+const maxReturnedEmpties = 1000
+
+// returnEmptiesRequest
+// The returned empties, either of the item with the given id, or scanned by their barcode. The barcode of a crate
+// stands for all of its bottles.
+type returnEmptiesRequest struct {
+	ItemId  string `json:"itemId"`
+	Barcode string `json:"barcode"`
+	Amount  int    `json:"amount"`
+}
+
+func (r *returnEmptiesRequest) Validate() error {
+	var err error
+	if (r.ItemId == "") == (r.Barcode == "") {
+		return errors.New("either itemId or barcode must be given")
+	}
+	if r.ItemId != "" {
+		r.ItemId, err = normalizeOptionalId(r.ItemId)
+	} else {
+		r.Barcode, err = items.NormalizeBarcode(r.Barcode, config.AlphanumericBarcodes)
+	}
+	if err != nil {
+		return err
+	}
+	if r.Amount == 0 {
+		r.Amount = 1
+	}
+	if r.Amount < 1 || r.Amount > maxReturnedEmpties {
+		return errors.New("amount must be between 1 and " + strconv.Itoa(maxReturnedEmpties))
+	}
+	return nil
+}
+
+var DailyTimeRegex = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+const maxPricingRuleValue = 100000
+
+type addPricingRuleRequest struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Value      int    `json:"value"`
+	ItemId     string `json:"itemId"`
+	CategoryId string `json:"categoryId"`
+	Role       string `json:"role"`
+	ValidFrom  int64  `json:"validFrom"`
+	ValidUntil int64  `json:"validUntil"`
+	DailyStart string `json:"dailyStart"`
+	DailyEnd   string `json:"dailyEnd"`
+	Priority   int    `json:"priority"`
+}
+
+func (r *addPricingRuleRequest) Validate() error {
+	if r.Name == "" || len(r.Name) > 64 {
+		return errors.New("name must not be empty or longer than 64 bytes")
+	}
+	switch r.Type {
+	case pricing.TypeFixed:
+		if r.Value < 0 || r.Value > maxPricingRuleValue {
+			return errors.New("the price must be between 0 and " + strconv.Itoa(maxPricingRuleValue))
+		}
+	case pricing.TypePercentage:
+		if r.Value < 0 || r.Value > 1000 {
+			return errors.New("the percentage must be between 0 and 1000")
+		}
+	case pricing.TypeBundle:
+		if r.Value < 1 || r.Value > 1000 {
+			return errors.New("the number of units to buy must be between 1 and 1000")
+		}
+	default:
+		return errors.New("type must be one of " + strings.Join(pricing.Types, ", "))
+	}
+	var err error
+	r.ItemId, err = normalizeOptionalId(r.ItemId)
+	if err != nil {
+		return err
+	}
+	r.CategoryId, err = normalizeOptionalId(r.CategoryId)
+	if err != nil {
+		return err
+	}
+	if r.ItemId != "" && r.CategoryId != "" {
+		return errors.New("a rule can either apply to an item or to a category")
+	}
+	// whether the role exists is checked by the handler, an empty role applies to everyone
+	if r.Role != "" && r.Role != pricing.RoleGuest && !RoleRegex.MatchString(r.Role) {
+		return errors.New("invalid role")
+	}
+	if r.ValidFrom < 0 || r.ValidUntil < 0 {
+		return errors.New("validFrom and validUntil must be unix timestamps")
+	}
+	if r.ValidFrom != 0 && r.ValidUntil != 0 && r.ValidUntil <= r.ValidFrom {
+		return errors.New("validUntil must be after validFrom")
+	}
+	if r.DailyStart == "" && r.DailyEnd == "" {
+		return nil
+	}
+	if !DailyTimeRegex.MatchString(r.DailyStart) || !DailyTimeRegex.MatchString(r.DailyEnd) {
+		return errors.New("dailyStart and dailyEnd must both be given as HH:MM")
+	}
+	if r.DailyStart == r.DailyEnd {
+		return errors.New("dailyStart and dailyEnd must not be equal")
+	}
+	return nil
+}
+
+func (r *addPricingRuleRequest) Rule(id string) pricing.Rule {
+	return pricing.Rule{
+		Id:         id,
+		Name:       r.Name,
+		Type:       r.Type,
+		Value:      r.Value,
+		ItemId:     r.ItemId,
+		CategoryId: r.CategoryId,
+		Role:       r.Role,
+		ValidFrom:  r.ValidFrom,
+		ValidUntil: r.ValidUntil,
+		DailyStart: r.DailyStart,
+		DailyEnd:   r.DailyEnd,
+		Priority:   r.Priority,
+	}
+}
+
+type updatePricingRuleRequest struct {
+	Id string `json:"id"`
+	addPricingRuleRequest
+}
+
+func (r *updatePricingRuleRequest) Validate() error {
+	id, err := uuid.Parse(r.Id)
+	if err != nil {
+		return err
+	}
+	r.Id = id.String()
+	return r.addPricingRuleRequest.Validate()
+}
+
 type addAuthMethodRequest struct {
 	Method string `json:"method"`
 	Data   string `json:"data"`
@@ -157,6 +654,9 @@ func (r *addAuthMethodRequest) Validate() error {
 }
 
 func (r *changeCreditRequest) Validate() error {
+	if r.Diff == 0 {
+		return errors.New("diff must not be zero")
+	}
 	return nil
 }
 
@@ -164,6 +664,66 @@ type changeCreditRequest struct {
 	Diff int `json:"diff"`
 }
 
+// updateUserRequest
+// The details of a user an admin can change, the credit is adjusted separately
+type updateUserRequest struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+func (r *updateUserRequest) Validate() error {
+	id, err := uuid.Parse(r.Id)
+	if err != nil {
+		return err
+	}
+	r.Id = id.String()
+	if !UsernameRegex.MatchString(r.Username) {
+		return errors.New("invalid username")
+	}
+	if !EmailRegex.MatchString(r.Email) {
+		return errors.New("invalid email")
+	}
+	if !RoleRegex.MatchString(r.Role) {
+		return errors.New("invalid role")
+	}
+	return nil
+}
+
+// adjustCreditRequest
+// A correction of the credit of a user by an admin, the reason is kept in the ledger
+type adjustCreditRequest struct {
+	Diff   int    `json:"diff"`
+	Reason string `json:"reason"`
+}
+
+func (r *adjustCreditRequest) Validate() error {
+	if r.Diff == 0 {
+		return errors.New("diff must not be zero")
+	}
+	r.Reason = strings.TrimSpace(r.Reason)
+	if r.Reason == "" || len(r.Reason) > 255 {
+		return errors.New("reason must not be empty or longer than 255 bytes")
+	}
+	return nil
+}
+
+type transferCreditRequest struct {
+	Username string `json:"username"`
+	Amount   int    `json:"amount"`
+}
+
+func (r *transferCreditRequest) Validate() error {
+	if !UsernameRegex.MatchString(r.Username) {
+		return errors.New("invalid username")
+	}
+	if r.Amount < 1 {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
 type requestPasswordResetRequest struct {
 	Username string `json:"username"`
 }
@@ -189,6 +749,61 @@ func (p *resetPasswordRequest) Validate() error {
 	return validatePassword(p.Password)
 }
 
+// changePasswordRequest
+// Users have to confirm their current password to set a new one
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func (r *changePasswordRequest) Validate() error {
+	if r.CurrentPassword == "" {
+		return errors.New("the current password is required")
+	}
+	if r.NewPassword == r.CurrentPassword {
+		return errors.New("the new password must differ from the current one")
+	}
+	return validatePassword(r.NewPassword)
+}
+
+// changeEmailRequest
+// Users with a password have to confirm it to change their email, as the email receives password reset mails
+type changeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (r *changeEmailRequest) Validate() error {
+	if !EmailRegex.MatchString(r.Email) {
+		return errors.New("invalid email")
+	}
+	return nil
+}
+
+type changeUsernameRequest struct {
+	Username string `json:"username"`
+}
+
+func (r *changeUsernameRequest) Validate() error {
+	if !UsernameRegex.MatchString(r.Username) {
+		return errors.New("invalid username")
+	}
+	return nil
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *verifyEmailRequest) Validate() error {
+	token, err := uuid.Parse(r.Token)
+	if err != nil {
+		return err
+	}
+	r.Token = token.String()
+	return nil
+}
+
 func validatePassword(password string) error {
 	if users.Entropy([]byte(password)) < 0.4 {
 		return errors.New("the password is not random enough")
@@ -198,3 +813,31 @@ func validatePassword(password string) error {
 	}
 	return nil
 }
+
+// roleRequest
+// Adds a role, or replaces the permissions of an existing one
+type roleRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+func (r *roleRequest) Validate() error {
+	if !RoleRegex.MatchString(r.Name) || r.Name == pricing.RoleGuest {
+		return errors.New("invalid role name")
+	}
+	permissions := make([]string, 0, len(r.Permissions))
+	for _, permission := range r.Permissions {
+		if !slices.Contains(users.Permissions, permission) {
+			return errors.New("unknown permission " + strconv.Quote(permission))
+		}
+		if !slices.Contains(permissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	r.Permissions = permissions
+	return nil
+}
+
+func (r *roleRequest) Role() users.Role {
+	return users.Role{Name: r.Name, Permissions: r.Permissions}
+}
@@ -36,6 +36,9 @@ func TestAddItemRequest_Validate(t *testing.T) {
 	req.Image = "AAAA"
 	testutils.ExpectErrorWithMessage(req.Validate(), "amount must not be negative", t)
 	req.Amount = 1
+	req.MinStock = -1
+	testutils.ExpectErrorWithMessage(req.Validate(), "minimum stock must not be negative", t)
+	req.MinStock = 5
 	testutils.FailOnError(req.Validate(), t)
 }
 
@@ -104,6 +107,27 @@ func TestUpdateItemRequest_Validate(t *testing.T) {
 		req.Amount = -1
 		testutils.ExpectErrorWithMessage(req.Validate(), "amount must not be negative", t)
 	})
+
+	t.Run("minimum stock can't be less than zero", func(t *testing.T) {
+		req := validUpdateRequest()
+		req.MinStock = -1
+		testutils.ExpectErrorWithMessage(req.Validate(), "minimum stock must not be negative", t)
+	})
+}
+
+func TestAddBarcodeRequest_Validate(t *testing.T) {
+	req := addBarcodeRequest{Barcode: "not a barcode"}
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid barcode", t)
+	req.Barcode = "4029764001808"
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid barcode", t)
+	req.Barcode = "036000291452"
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.Barcode, "0036000291452", t)
+	testutils.ExpectEqual(req.Multiplier, 1, t)
+	req.Multiplier = -20
+	testutils.ExpectError(req.Validate(), t)
+	req.Multiplier = 20
+	testutils.FailOnError(req.Validate(), t)
 }
 
 func TestBuyItemRequest_Validate(t *testing.T) {
@@ -119,6 +143,36 @@ func TestBuyItemRequest_Validate(t *testing.T) {
 	testutils.FailOnError(req.Validate(), t)
 }
 
+func TestBuyItemRequest_ValidateCart(t *testing.T) {
+	req := buyItemRequest{
+		Items: []cartLine{
+			{ItemId: "00000000000000000000000000000001", Amount: 1},
+			{ItemId: "00000000-0000-0000-0000-000000000002", Amount: 0},
+		},
+	}
+	testutils.ExpectErrorWithMessage(req.Validate(), "amount must be at least one item", t)
+	req.Items[1].Amount = 2
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.Items[0].ItemId, "00000000-0000-0000-0000-000000000001", t)
+
+	lines := req.CartLines()
+	testutils.ExpectEqual(len(lines), 2, t)
+	testutils.ExpectEqual(lines[1].Amount, 2, t)
+
+	req.ItemId = "00000000-0000-0000-0000-000000000003"
+	testutils.ExpectErrorWithMessage(req.Validate(), "either itemId or items must be given, not both", t)
+
+	req.ItemId = ""
+	req.Items = make([]cartLine, maxCartLines+1)
+	testutils.ExpectErrorWithMessage(req.Validate(), "too many items in the cart", t)
+
+	// a single item is turned into a cart with one line
+	single := buyItemRequest{ItemId: "00000000-0000-0000-0000-000000000001", Amount: 3}
+	testutils.FailOnError(single.Validate(), t)
+	testutils.ExpectEqual(len(single.CartLines()), 1, t)
+	testutils.ExpectEqual(single.CartLines()[0].Amount, 3, t)
+}
+
 func TestAddAuthMethodRequest_Validate(t *testing.T) {
 	req := addAuthMethodRequest{
 		Method: "none",
@@ -137,6 +191,22 @@ func TestAddAuthMethodRequest_Validate(t *testing.T) {
 	testutils.ExpectErrorWithMessage(req.Validate(), "invalid method", t)
 }
 
+func TestChangeCreditRequest_Validate(t *testing.T) {
+	req := changeCreditRequest{Diff: 0}
+	testutils.ExpectErrorWithMessage(req.Validate(), "diff must not be zero", t)
+	req.Diff = -5
+	testutils.FailOnError(req.Validate(), t)
+}
+
+func TestTransferCreditRequest_Validate(t *testing.T) {
+	req := transferCreditRequest{Username: "invalid username", Amount: 0}
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid username", t)
+	req.Username = "valid_user"
+	testutils.ExpectErrorWithMessage(req.Validate(), "amount must be positive", t)
+	req.Amount = 100
+	testutils.FailOnError(req.Validate(), t)
+}
+
 func TestRequestPasswordResetRequest_Validate(t *testing.T) {
 	req := requestPasswordResetRequest{Username: "invalid username"}
 	testutils.ExpectErrorWithMessage(req.Validate(), "invalid username", t)
@@ -154,3 +224,175 @@ func TestResetPasswordRequest_Validate(t *testing.T) {
 	testutils.FailOnError(req.Validate(), t)
 	testutils.ExpectSuccess(req.Token == "00000000-0000-0000-0000-000000000000", t)
 }
+
+func TestRestockRequest_Validate(t *testing.T) {
+	req := restockRequest{Amount: 0, UnitCost: 80}
+	testutils.ExpectErrorWithMessage(req.Validate(), "amount must be at least one item", t)
+	req.Amount = 24
+	req.UnitCost = -1
+	testutils.ExpectErrorWithMessage(req.Validate(), "unit cost must not be negative", t)
+	req.UnitCost = 80
+	req.Supplier = strings.Repeat("x", 65)
+	testutils.ExpectErrorWithMessage(req.Validate(), "supplier must not be longer than 64 bytes", t)
+	req.Supplier = "Getränke Hoffmann"
+	testutils.FailOnError(req.Validate(), t)
+}
+
+func TestRestockDeliveryRequest_Validate(t *testing.T) {
+	req := restockDeliveryRequest{Supplier: "Getränke Hoffmann"}
+	testutils.ExpectErrorWithMessage(req.Validate(), "the delivery contains no items", t)
+	req.Items = []deliveryLine{{ItemId: "00000000000000000000000000000001", Amount: 24, UnitCost: 80}}
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.Items[0].ItemId, "00000000-0000-0000-0000-000000000001", t)
+
+	req.Items = append(req.Items, deliveryLine{ItemId: "invalid uuid", Amount: 1})
+	testutils.ExpectError(req.Validate(), t)
+	req.Items[1] = deliveryLine{ItemId: "00000000-0000-0000-0000-000000000002", Amount: 0}
+	testutils.ExpectErrorWithMessage(req.Validate(), "amount must be at least one item", t)
+	req.Items = make([]deliveryLine, maxDeliveryLines+1)
+	testutils.ExpectErrorWithMessage(req.Validate(), "too many items in the delivery", t)
+}
+
+func TestStocktakeRequest_Validate(t *testing.T) {
+	req := stocktakeRequest{}
+	testutils.ExpectErrorWithMessage(req.Validate(), "no items have been counted", t)
+	req.Items = []countedItem{
+		{ItemId: "00000000000000000000000000000001", Amount: 0},
+		{ItemId: "00000000-0000-0000-0000-000000000002", Amount: 12},
+	}
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.Items[0].ItemId, "00000000-0000-0000-0000-000000000001", t)
+
+	req.Items[1].Amount = -1
+	testutils.ExpectErrorWithMessage(req.Validate(), "amount must not be negative", t)
+	req.Items[1].ItemId = "00000000-0000-0000-0000-000000000001"
+	testutils.ExpectErrorWithMessage(req.Validate(), "every item must only be counted once", t)
+	req.Items[1].ItemId = "invalid uuid"
+	testutils.ExpectError(req.Validate(), t)
+}
+
+func TestReturnEmptiesRequest_Validate(t *testing.T) {
+	req := returnEmptiesRequest{}
+	testutils.ExpectErrorWithMessage(req.Validate(), "either itemId or barcode must be given", t)
+	req = returnEmptiesRequest{ItemId: "00000000000000000000000000000001", Barcode: "4029764001807"}
+	testutils.ExpectErrorWithMessage(req.Validate(), "either itemId or barcode must be given", t)
+
+	req.Barcode = ""
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.ItemId, "00000000-0000-0000-0000-000000000001", t)
+	// a single bottle is returned by default
+	testutils.ExpectEqual(req.Amount, 1, t)
+	req.Amount = -1
+	testutils.ExpectErrorWithMessage(req.Validate(), "amount must be between 1 and 1000", t)
+
+	req = returnEmptiesRequest{Barcode: "4029764001808"}
+	testutils.ExpectError(req.Validate(), t)
+	req.Barcode = "4029764001807"
+	testutils.FailOnError(req.Validate(), t)
+}
+
+func TestAddPricingRuleRequest_Validate(t *testing.T) {
+	req := addPricingRuleRequest{Name: "Happy hour", Type: "discount", Value: 50}
+	testutils.ExpectErrorWithMessage(req.Validate(), "type must be one of fixed, percentage, bundle", t)
+	req.Type = "percentage"
+	req.Value = 1001
+	testutils.ExpectErrorWithMessage(req.Validate(), "the percentage must be between 0 and 1000", t)
+	req.Value = 50
+	req.ItemId, req.CategoryId = "00000000000000000000000000000001", "00000000000000000000000000000002"
+	testutils.ExpectErrorWithMessage(req.Validate(), "a rule can either apply to an item or to a category", t)
+	req.ItemId = ""
+	req.Role = "Member"
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid role", t)
+	req.Role = "guest"
+	req.DailyStart = "18:00"
+	testutils.ExpectErrorWithMessage(req.Validate(), "dailyStart and dailyEnd must both be given as HH:MM", t)
+	req.DailyEnd = "24:00"
+	testutils.ExpectError(req.Validate(), t)
+	req.DailyEnd = "19:00"
+	req.ValidFrom, req.ValidUntil = 1700000000, 1600000000
+	testutils.ExpectErrorWithMessage(req.Validate(), "validUntil must be after validFrom", t)
+	req.ValidUntil = 1800000000
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.CategoryId, "00000000-0000-0000-0000-000000000002", t)
+
+	req.Type = "bundle"
+	req.Value = 0
+	testutils.ExpectErrorWithMessage(req.Validate(), "the number of units to buy must be between 1 and 1000", t)
+	update := updatePricingRuleRequest{Id: "invalid", addPricingRuleRequest: req}
+	testutils.ExpectError(update.Validate(), t)
+}
+
+func TestUpdateUserRequest_Validate(t *testing.T) {
+	req := updateUserRequest{Id: "00000000000000000000000000000001", Username: "renamed", Email: "invalid", Role: "user"}
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid email", t)
+	req.Email = "renamed@godrink.test"
+	req.Role = "a"
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid role", t)
+	req.Role = "treasurer"
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.Id, "00000000-0000-0000-0000-000000000001", t)
+}
+
+func TestAdjustCreditRequest_Validate(t *testing.T) {
+	req := adjustCreditRequest{Diff: 500, Reason: "  "}
+	testutils.ExpectErrorWithMessage(req.Validate(), "reason must not be empty or longer than 255 bytes", t)
+	req.Reason = strings.Repeat("a", 256)
+	testutils.ExpectError(req.Validate(), t)
+	req.Reason = " cash deposited into the box "
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.Reason, "cash deposited into the box", t)
+	req.Diff = 0
+	testutils.ExpectErrorWithMessage(req.Validate(), "diff must not be zero", t)
+}
+
+func TestRoleRequest_Validate(t *testing.T) {
+	req := roleRequest{Name: "guest"}
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid role name", t)
+	req.Name = "bar team"
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid role name", t)
+	req.Name = "bartender"
+	req.Permissions = []string{"items:buy", "items:drink"}
+	testutils.ExpectErrorWithMessage(req.Validate(), `unknown permission "items:drink"`, t)
+	req.Permissions = []string{"items:buy", "stock:restock", "items:buy"}
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(len(req.Permissions), 2, t)
+	req.Permissions = nil
+	testutils.FailOnError(req.Validate(), t)
+}
+
+func TestChangePasswordRequest_Validate(t *testing.T) {
+	req := changePasswordRequest{NewPassword: securePassword}
+	testutils.ExpectErrorWithMessage(req.Validate(), "the current password is required", t)
+	req.CurrentPassword = securePassword
+	testutils.ExpectErrorWithMessage(req.Validate(), "the new password must differ from the current one", t)
+	req.NewPassword = "aaaaaaaaaaaaaaaaaaaa"
+	testutils.ExpectErrorWithMessage(req.Validate(), "the password is not random enough", t)
+	req.CurrentPassword = "old password"
+	req.NewPassword = securePassword
+	testutils.FailOnError(req.Validate(), t)
+}
+
+func TestChangeEmailRequest_Validate(t *testing.T) {
+	req := changeEmailRequest{Email: "invalid", Password: securePassword}
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid email", t)
+	req.Email = "new@godrink.test"
+	testutils.FailOnError(req.Validate(), t)
+	// users without a password confirm the change with the mailed token alone
+	req.Password = ""
+	testutils.FailOnError(req.Validate(), t)
+}
+
+func TestChangeUsernameRequest_Validate(t *testing.T) {
+	req := changeUsernameRequest{Username: "no spaces allowed"}
+	testutils.ExpectErrorWithMessage(req.Validate(), "invalid username", t)
+	req.Username = "renamed"
+	testutils.FailOnError(req.Validate(), t)
+}
+
+func TestVerifyEmailRequest_Validate(t *testing.T) {
+	req := verifyEmailRequest{Token: "invalid"}
+	testutils.ExpectError(req.Validate(), t)
+	req.Token = "00000000000000000000000000000001"
+	testutils.FailOnError(req.Validate(), t)
+	testutils.ExpectEqual(req.Token, "00000000-0000-0000-0000-000000000001", t)
+}
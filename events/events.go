@@ -0,0 +1,83 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	TypeLowStock = "item.low-stock"
+)
+
+// Event
+// Something that happened, which other parts of the application or other systems may react to. The data depends on
+// the type of the event.
+type Event struct {
+	Type      string `json:"type"`
+	Timestamp int64  `json:"timestamp"`
+	Data      any    `json:"data"`
+}
+
+// LowStock
+// The data of a TypeLowStock event, the amount of the item has fallen below its minimum stock
+type LowStock struct {
+	ItemId   string `json:"itemId"`
+	ItemName string `json:"itemName"`
+	Amount   int    `json:"amount"`
+	MinStock int    `json:"minStock"`
+}
+
+type Handler func(Event)
+
+var (
+	mutex    sync.RWMutex
+	handlers []Handler
+)
+
+// Subscribe
+// Call the handler for every published event
+func Subscribe(handler Handler) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	handlers = append(handlers, handler)
+}
+
+// Publish
+// Pass the event to all handlers. The handlers run in the background, so slow handlers like sending mails don't
+// delay the request which caused the event.
+func Publish(eventType string, data any) {
+	event := Event{Type: eventType, Timestamp: time.Now().Unix(), Data: data}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, handler := range handlers {
+		go handler(event)
+	}
+}
+
+var webhookClient = http.Client{Timeout: 10 * time.Second}
+
+// Webhook
+// A handler posting every event as json to the url
+func Webhook(url string) Handler {
+	return func(event Event) {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Println("Error while encoding event:", err)
+			return
+		}
+		response, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Println("Error while posting event to webhook:", err)
+			return
+		}
+		defer response.Body.Close()
+		if response.StatusCode >= 300 {
+			log.Printf("The webhook rejected the %s event: %s\n", event.Type, response.Status)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestWebhook(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		testutils.FailOnError(json.NewDecoder(r.Body).Decode(&event), t)
+		received <- event
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	Subscribe(Webhook(server.URL))
+	Publish(TypeLowStock, LowStock{ItemId: "00000000-0000-0000-0000-000000000001", ItemName: "Mate", Amount: 4, MinStock: 5})
+
+	select {
+	case event := <-received:
+		testutils.ExpectEqual(event.Type, TypeLowStock, t)
+		data, ok := event.Data.(map[string]any)
+		testutils.ExpectSuccess(ok, t)
+		testutils.ExpectEqual(data["itemName"], any("Mate"), t)
+		testutils.ExpectEqual(data["amount"], any(float64(4)), t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event has not been posted to the webhook")
+	}
+}
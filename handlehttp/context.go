@@ -14,12 +14,15 @@ type key string
 const contextKeyStatus key = "status"
 const contextKeySession key = "session"
 const contextKeySessionToken key = "sessionToken"
+const contextKeyNextPage key = "nextPage"
 
 type ContextStruct struct {
 	Status     int
 	HasError   bool
 	Session    *session.Session
 	HasSession bool
+	// NextPage is the url of the next page of a paginated result, or empty if there is none
+	NextPage string
 }
 
 func CtxToStruct(ctx context.Context) ContextStruct {
@@ -37,12 +40,14 @@ func CtxToStruct(ctx context.Context) ContextStruct {
 		status = http.StatusInternalServerError
 	}
 	hasError := status >= 400
+	nextPage, _ := ContextGetNextPage(ctx)
 
 	return ContextStruct{
 		Session:    sessionRef,
 		HasSession: hasSession,
 		Status:     status,
 		HasError:   hasError,
+		NextPage:   nextPage,
 	}
 }
 
@@ -61,6 +66,10 @@ func ContextWithSessionToken(ctx context.Context, sessionToken string) context.C
 	return context.WithValue(ctx, contextKeySessionToken, sessionToken)
 }
 
+func ContextWithNextPage(ctx context.Context, nextPage string) context.Context {
+	return context.WithValue(ctx, contextKeyNextPage, nextPage)
+}
+
 func contextGet[T interface{}](ctx context.Context, key key) (T, bool) {
 	u, ok := ctx.Value(key).(T)
 	return u, ok
@@ -77,3 +86,7 @@ func ContextGetSession(ctx context.Context) (*session.Session, bool) {
 func ContextGetSessionToken(ctx context.Context) (string, bool) {
 	return contextGet[string](ctx, contextKeySessionToken)
 }
+
+func ContextGetNextPage(ctx context.Context) (string, bool) {
+	return contextGet[string](ctx, contextKeyNextPage)
+}
@@ -0,0 +1,43 @@
+package handlehttp
+
+import (
+	"log"
+	"net/http"
+)
+
+// File
+// Raw content like an image, which is written to the response as it is
+type File struct {
+	ContentType  string
+	Data         []byte
+	ETag         string
+	CacheControl string
+}
+
+// WriteFile
+// Write the file, or fall back to json, e.g. for errors. The body is omitted for 304 responses.
+func WriteFile(w http.ResponseWriter, input MappingInput) {
+	file, ok := input.Data.(File)
+	if !ok {
+		WriteAsJson(w, input)
+		return
+	}
+	if file.ETag != "" {
+		w.Header().Set("ETag", file.ETag)
+	}
+	if file.CacheControl != "" {
+		w.Header().Set("Cache-Control", file.CacheControl)
+	}
+	if input.Ctx.Status == http.StatusNotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", file.ContentType)
+	w.WriteHeader(input.Ctx.Status)
+	_, err := w.Write(file.Data)
+	if err != nil {
+		log.Println("Error writing response", err)
+	}
+}
+
+var FileMapper ResponseMapper = WriteFile
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"time"
 )
 
 func hasField(v interface{}, name string) bool {
@@ -19,10 +20,15 @@ func hasField(v interface{}, name string) bool {
 	return rv.FieldByName(name).IsValid()
 }
 
+func formatTime(timestamp int64) string {
+	return time.Unix(timestamp, 0).Format(time.DateTime)
+}
+
 func HtmlMapper(tplFS fs.FS, useFragment bool, tplPaths ...string) ResponseMapper {
 	templates := append(tplPaths, "base-templates/*.gohtml", "component-templates/*.gohtml")
 	tpl := template.Must(template.New("template").Funcs(template.FuncMap{
-		"hasField": hasField,
+		"hasField":   hasField,
+		"formatTime": formatTime,
 	}).ParseFS(tplFS, templates...))
 
 	return func(w http.ResponseWriter, input MappingInput) {
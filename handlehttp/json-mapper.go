@@ -22,6 +22,9 @@ func WriteAsJson(w http.ResponseWriter, input MappingInput) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if input.Ctx.NextPage != "" {
+		w.Header().Set("Link", "<"+input.Ctx.NextPage+">; rel=\"next\"")
+	}
 	w.WriteHeader(status)
 
 	if resp != nil {
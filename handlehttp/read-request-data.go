@@ -1,6 +1,7 @@
 package handlehttp
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	contenttype "github.com/Port39/go-drink/handlehttp/content-type"
@@ -52,6 +53,51 @@ func readValidFormBody[T any](r *http.Request, dest *T) error {
 	return nil
 }
 
+// maxMultipartMemory is the amount of a multipart body kept in memory, the rest is stored in temporary files
+const maxMultipartMemory = 8 << 20
+
+var multipartFormData = contenttype.MediaType{
+	Type:    "multipart",
+	Subtype: "form-data",
+}
+
+// readValidMultipartBody
+// Decode a multipart form. Uploaded files are passed base64 encoded, just like binary data in json bodies.
+func readValidMultipartBody[T any](r *http.Request, dest *T) error {
+	err := r.ParseMultipartForm(maxMultipartMemory)
+	if err != nil {
+		return logAndCreateError("error parsing multipart form", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	values := make(map[string][]string, len(r.MultipartForm.Value)+len(r.MultipartForm.File))
+	for key, value := range r.MultipartForm.Value {
+		values[key] = value
+	}
+	for key, headers := range r.MultipartForm.File {
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				return logAndCreateError("error reading uploaded file", err)
+			}
+			data, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return logAndCreateError("error reading uploaded file", err)
+			}
+			values[key] = append(values[key], base64.StdEncoding.EncodeToString(data))
+		}
+	}
+
+	err = decoder.Decode(dest, values)
+	if err != nil {
+		return logAndCreateError("error decoding form", err)
+	}
+	return nil
+}
+
+// ReadValidBody
+// Decode the body according to its content type, and reject it unless its Validate method accepts it
 func ReadValidBody[T any, PT interface {
 	Validatable
 	*T
@@ -63,14 +109,22 @@ func ReadValidBody[T any, PT interface {
 		return nil, logAndCreateError("error ascertaining content type", err)
 	}
 
-	if Json.Equal(mediatype) {
+	// parameters like the charset are irrelevant for choosing the decoder
+	if Json.EqualsMIME(mediatype) {
 		err = readValidJsonBody(req, parsed)
+	} else if multipartFormData.EqualsMIME(mediatype) {
+		err = readValidMultipartBody(req, parsed)
 	} else {
 		err = readValidFormBody(req, parsed)
 	}
 
 	if err != nil {
-		return nil, logAndCreateError("error ascertaining content type", err)
+		return nil, err
+	}
+
+	err = PT(parsed).Validate()
+	if err != nil {
+		return nil, err
 	}
 
 	return parsed, nil
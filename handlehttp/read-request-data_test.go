@@ -0,0 +1,93 @@
+package handlehttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testRequest struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+	File   string `json:"file"`
+}
+
+func (r *testRequest) Validate() error {
+	if r.Amount < 1 {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
+func TestReadValidBody(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		expectError bool
+	}{
+		{"json", "application/json", `{"name":"Mate","amount":2}`, false},
+		{"json with charset", "application/json; charset=utf-8", `{"name":"Mate","amount":2}`, false},
+		{"form", "application/x-www-form-urlencoded", "name=Mate&amount=2", false},
+		{"invalid json", "application/json", `{"name":`, true},
+		{"invalid body", "application/json", `{"name":"Mate","amount":0}`, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", tc.contentType)
+			parsed, err := ReadValidBody[testRequest](r)
+			if tc.expectError {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if parsed.Name != "Mate" || parsed.Amount != 2 {
+				t.Fatalf("unexpected result: %+v", *parsed)
+			}
+		})
+	}
+}
+
+func TestReadValidBody_Multipart(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("name", "Mate"); err != nil {
+		t.Fatal(err)
+	}
+	if err := form.WriteField("amount", "2"); err != nil {
+		t.Fatal(err)
+	}
+	file, err := form.CreateFormFile("file", "mate.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.Write([]byte{0x89, 'P', 'N', 'G'}); err != nil {
+		t.Fatal(err)
+	}
+	if err = form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	parsed, err := ReadValidBody[testRequest](r)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if parsed.Name != "Mate" || parsed.Amount != 2 {
+		t.Fatalf("unexpected result: %+v", *parsed)
+	}
+	// files are base64 encoded
+	if parsed.File != base64.StdEncoding.EncodeToString([]byte{0x89, 'P', 'N', 'G'}) {
+		t.Fatalf("unexpected file content: %s", parsed.File)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"embed"
 	"github.com/Port39/go-drink/handlehttp"
+	"github.com/Port39/go-drink/pagination"
 	"github.com/Port39/go-drink/session"
 	"github.com/Port39/go-drink/users"
 	"io/fs"
@@ -48,7 +49,9 @@ func enrichRequestContext(next handlehttp.RequestHandler) handlehttp.RequestHand
 	}
 }
 
-func verifyRole(role string, next handlehttp.RequestHandler) handlehttp.RequestHandler {
+// requirePermission
+// Only let users whose role grants the permission through to the next handler
+func requirePermission(permission string, next handlehttp.RequestHandler) handlehttp.RequestHandler {
 	return func(r *http.Request) (context.Context, any) {
 		s, hasSession := handlehttp.ContextGetSession(r.Context())
 		if !hasSession {
@@ -56,15 +59,42 @@ func verifyRole(role string, next handlehttp.RequestHandler) handlehttp.RequestH
 			return errorWithContextAndDetail(r.Context(), http.StatusUnauthorized, "You need to be logged in to do that!")
 		}
 
-		if !users.CheckRole(s.Role, role) {
-			log.Println("Rejecting request due to lacking role.")
-			return errorWithContextAndDetail(r.Context(), http.StatusForbidden, "You are not allowed to do that. You need to be logged in as a user with the role \""+role+"\".")
+		allowed, err := users.HasPermission(r.Context(), s.Role, permission, database)
+		if err != nil {
+			log.Println("Could not check permissions:", err)
+			return errorWithContext(r.Context(), http.StatusInternalServerError)
+		}
+		if !allowed {
+			log.Println("Rejecting request due to lacking permission.")
+			return errorWithContextAndDetail(r.Context(), http.StatusForbidden, "You are not allowed to do that. You need the permission \""+permission+"\".")
 		}
 
 		return next(r)
 	}
 }
 
+// onlyAdminsManageAdmins is the detail of the error returned by handlers which refuse to change an admin account
+const onlyAdminsManageAdmins = "only admins can change admin accounts"
+
+// mayManageUser
+// Admins have every permission, so only admins may change their accounts. Otherwise anyone allowed to manage users
+// could take over an admin account, e.g. by changing its email and resetting the password.
+func mayManageUser(s *session.Session, user users.User) bool {
+	return user.Role != users.RoleAdmin || s.Role == users.RoleAdmin
+}
+
+// withNextPage
+// Point to the next page of a paginated result, if there is one. The query of the request is kept, so filters and the
+// sort order apply to the next page as well.
+func withNextPage(ctx context.Context, r *http.Request, next *pagination.Cursor) context.Context {
+	if next == nil {
+		return ctx
+	}
+	query := r.URL.Query()
+	query.Set("cursor", next.String())
+	return handlehttp.ContextWithNextPage(ctx, r.URL.Path+"?"+query.Encode())
+}
+
 //go:embed html-frontend/**/*.gohtml
 var rawHtmlTemplates embed.FS
 
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"log"
 	"net/http"
-	"regexp"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -14,7 +16,12 @@ import (
 	"github.com/Port39/go-drink/handlehttp"
 	contenttype "github.com/Port39/go-drink/handlehttp/content-type"
 	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/mailing"
+	"github.com/Port39/go-drink/pagination"
+	"github.com/Port39/go-drink/pricing"
+	"github.com/Port39/go-drink/reports"
 	"github.com/Port39/go-drink/session"
+	"github.com/Port39/go-drink/stock"
 	"github.com/Port39/go-drink/transactions"
 	"github.com/Port39/go-drink/users"
 	"github.com/google/uuid"
@@ -28,14 +35,62 @@ func errorWithContextAndDetail(ctx context.Context, status int, detail string) (
 }
 
 var getItems handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
-	allItems, err := items.GetAllItems(r.Context(), database)
+	query := r.URL.Query()
+	page, err := pagination.FromQuery(query, items.Sorts, "category")
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	filter := items.Filter{
+		IncludeArchived: query.Get("includeArchived") == "true",
+	}
+	if query.Get("tag") != "" {
+		// tags are stored normalized, so the filter has to be too
+		tags, err := normalizeTags([]string{query.Get("tag")})
+		if err != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+		}
+		filter.Tag = tags[0]
+	}
+	if filter.IncludeArchived {
+		s, hasSession := handlehttp.ContextGetSession(r.Context())
+		if !hasSession {
+			return errorWithContextAndDetail(r.Context(), http.StatusForbidden,
+				"listing archived items needs the permission \""+users.PermissionItemsWrite+"\"")
+		}
+		allowed, err := users.HasPermission(r.Context(), s.Role, users.PermissionItemsWrite, database)
+		if err != nil {
+			log.Println("Could not check permissions:", err)
+			return errorWithContext(r.Context(), http.StatusInternalServerError)
+		}
+		if !allowed {
+			return errorWithContextAndDetail(r.Context(), http.StatusForbidden,
+				"listing archived items needs the permission \""+users.PermissionItemsWrite+"\"")
+		}
+	}
+	if query.Has("category") {
+		id, err := uuid.Parse(query.Get("category"))
+		if err != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid category id, uuid expected")
+		}
+		filter.CategoryId = id.String()
+	}
+	allItems, next, err := items.GetItems(r.Context(), filter, page, database)
 
 	if err != nil {
 		log.Println("Error while retrieving items from database:", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
 
-	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), allItems
+	return withNextPage(handlehttp.ContextWithStatus(r.Context(), http.StatusOK), r, next), allItems
+}
+
+var getLowStockItems handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	lowStock, err := items.GetLowStockItems(r.Context(), database)
+	if err != nil {
+		log.Println("Error while retrieving items low on stock from database:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), lowStock
 }
 
 var addItem handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
@@ -51,17 +106,32 @@ var addItem handlehttp.RequestHandler = func(r *http.Request) (context.Context,
 	if err == nil {
 		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "Item already exists!")
 	}
+	var category items.Category
+	if req.CategoryId != "" {
+		category, err = items.GetCategoryById(r.Context(), req.CategoryId, database)
+		if err != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+		}
+	}
 
 	item := items.Item{
-		Name:    req.Name,
-		Price:   req.Price,
-		Image:   req.Image,
-		Amount:  req.Amount,
-		Id:      uuid.New().String(),
-		Barcode: req.Barcode,
+		Name:         req.Name,
+		Price:        req.Price,
+		Image:        req.ImageData(),
+		Amount:       req.Amount,
+		Id:           uuid.New().String(),
+		Barcodes:     req.Barcodes(),
+		CategoryId:   req.CategoryId,
+		CategoryName: category.Name,
+		Tags:         req.Tags,
+		MinStock:     req.MinStock,
+		Deposit:      req.Deposit,
 	}
 	err = items.InsertNewItem(r.Context(), &item, database)
 
+	if errors.Is(err, items.ErrBarcodeInUse) {
+		return errorWithContextAndDetail(r.Context(), http.StatusConflict, err.Error())
+	}
 	if err != nil {
 		log.Println("Error while inserting new item", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
@@ -81,30 +151,439 @@ var updateItem handlehttp.RequestHandler = func(r *http.Request) (context.Contex
 	if err == nil && item.Id != req.Id {
 		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "an item with this name already exits")
 	}
-	err = items.UpdateItem(r.Context(), &items.Item{
-		Name:    req.Name,
-		Price:   req.Price,
-		Image:   req.Image,
-		Amount:  req.Amount,
-		Id:      req.Id,
-		Barcode: req.Barcode,
-	}, database)
+	if req.CategoryId != "" {
+		_, err = items.GetCategoryById(r.Context(), req.CategoryId, database)
+		if err != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+		}
+	}
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	err = stock.UpdateItem(r.Context(), &items.Item{
+		Name:       req.Name,
+		Price:      req.Price,
+		Image:      req.ImageData(),
+		Amount:     req.Amount,
+		Id:         req.Id,
+		Barcodes:   req.Barcodes(),
+		CategoryId: req.CategoryId,
+		Tags:       req.Tags,
+		MinStock:   req.MinStock,
+		Deposit:    req.Deposit,
+	}, s.UserId, database)
+	if errors.Is(err, items.ErrNoSuchItem) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if err == nil && req.RemoveImage {
+		err = items.RemoveImage(r.Context(), req.Id, database)
+	}
+	if errors.Is(err, items.ErrBarcodeInUse) {
+		return errorWithContextAndDetail(r.Context(), http.StatusConflict, err.Error())
+	}
 
 	if err != nil {
 		log.Println("Error while updating item", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
 
+	item, err = items.GetItemById(r.Context(), req.Id, database)
+	if err != nil {
+		return errorWithContext(r.Context(), http.StatusNotFound)
+	}
 	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), item
 }
 
+// setItemArchived
+// Archive the item, or bring it back into the assortment
+func setItemArchived(archived bool) handlehttp.RequestHandler {
+	return func(r *http.Request) (context.Context, any) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item id, uuid expected")
+		}
+		err = items.SetArchived(r.Context(), id.String(), archived, database)
+		if errors.Is(err, items.ErrNoSuchItem) {
+			return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			log.Println("Error while archiving item", err)
+			return errorWithContext(r.Context(), http.StatusInternalServerError)
+		}
+		item, err := items.GetItemById(r.Context(), id.String(), database)
+		if err != nil {
+			return errorWithContext(r.Context(), http.StatusNotFound)
+		}
+		return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), item
+	}
+}
+
+var deleteItem handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item id, uuid expected")
+	}
+	err = items.DeleteItem(r.Context(), id.String(), database)
+	switch {
+	case errors.Is(err, items.ErrNoSuchItem):
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	case errors.Is(err, items.ErrItemSold):
+		return errorWithContextAndDetail(r.Context(), http.StatusConflict, err.Error())
+	case err != nil:
+		log.Println("Error while deleting item", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusNoContent), nil
+}
+
+var restockItem handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item id, uuid expected")
+	}
+	req, err := handlehttp.ReadValidBody[restockRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	movements, err := stock.Restock(r.Context(), s.UserId, stock.Delivery{
+		Supplier: req.Supplier,
+		Lines:    []stock.DeliveryLine{{ItemId: id.String(), Amount: req.Amount, UnitCost: req.UnitCost}},
+	}, database)
+	if err != nil {
+		return restockError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), movements[0]
+}
+
+var restockDelivery handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	req, err := handlehttp.ReadValidBody[restockDeliveryRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	movements, err := stock.Restock(r.Context(), s.UserId, req.Delivery(), database)
+	if err != nil {
+		return restockError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), movements
+}
+
+func restockError(ctx context.Context, err error) (context.Context, any) {
+	if errors.Is(err, items.ErrNoSuchItem) {
+		return errorWithContextAndDetail(ctx, http.StatusNotFound, err.Error())
+	}
+	log.Println("Error while restocking items", err)
+	return errorWithContext(ctx, http.StatusInternalServerError)
+}
+
+var getStockMovements handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	query := r.URL.Query()
+	page, err := pagination.FromQuery(query, stock.Sorts, "-timestamp")
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	// the stock movement filter has the same parameters as the transaction filter
+	transactionFilter, err := readTransactionFilter(query)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	filter := stock.Filter{
+		ItemId: transactionFilter.ItemId,
+		UserId: transactionFilter.UserId,
+		Type:   transactionFilter.Type,
+		Since:  transactionFilter.Since,
+		Until:  transactionFilter.Until,
+	}
+	if query.Has("delivery") {
+		id, err := uuid.Parse(query.Get("delivery"))
+		if err != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid delivery id, uuid expected")
+		}
+		filter.DeliveryId = id.String()
+	}
+	if query.Has("stocktake") {
+		id, err := uuid.Parse(query.Get("stocktake"))
+		if err != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid stocktake id, uuid expected")
+		}
+		filter.StocktakeId = id.String()
+	}
+	movements, next, err := stock.GetMovements(r.Context(), filter, page, database)
+	if err != nil {
+		log.Println("Error while retrieving stock movements from database:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return withNextPage(handlehttp.ContextWithStatus(r.Context(), http.StatusOK), r, next), movements
+}
+
+var takeStock handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	req, err := handlehttp.ReadValidBody[stocktakeRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	stocktake, err := stock.TakeStock(r.Context(), s.UserId, req.CountedItems(), database)
+	if errors.Is(err, items.ErrNoSuchItem) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while taking stock", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), stocktake
+}
+
+var getShrinkage handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	// only the time range of the transaction filter is used
+	filter, err := readTransactionFilter(r.URL.Query())
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	report, err := stock.GetShrinkage(r.Context(), filter.Since, filter.Until, database)
+	if err != nil {
+		log.Println("Error while retrieving shrinkage from database:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), report
+}
+
+var getCategories handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	categories, err := items.GetCategories(r.Context(), database)
+	if err != nil {
+		log.Println("Error while retrieving categories from database:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), categories
+}
+
+var addCategory handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[addCategoryRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	_, err = items.GetCategoryByName(r.Context(), req.Name, database)
+	if err == nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "a category with this name already exists")
+	}
+	category := items.Category{
+		Id:       uuid.New().String(),
+		Name:     req.Name,
+		Position: req.Position,
+	}
+	err = items.InsertNewCategory(r.Context(), category, database)
+	if err != nil {
+		log.Println("Error while inserting new category", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), category
+}
+
+var updateCategory handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[updateCategoryRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	existing, err := items.GetCategoryByName(r.Context(), req.Name, database)
+	if err == nil && existing.Id != req.Id {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "a category with this name already exists")
+	}
+	category := items.Category{
+		Id:       req.Id,
+		Name:     req.Name,
+		Position: req.Position,
+	}
+	err = items.UpdateCategory(r.Context(), category, database)
+	if errors.Is(err, items.ErrNoSuchCategory) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while updating category", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), category
+}
+
+var deleteCategory handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid category id, uuid expected")
+	}
+	err = items.DeleteCategory(r.Context(), id.String(), database)
+	if errors.Is(err, items.ErrNoSuchCategory) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while deleting category", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusNoContent), nil
+}
+
+var getPricingRules handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	rules, err := pricing.GetRules(r.Context(), database)
+	if err != nil {
+		log.Println("Error while retrieving pricing rules from database:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), rules
+}
+
+// checkPricingRuleScope
+// Check that the item or category and the role a pricing rule applies to exist
+func checkPricingRuleScope(ctx context.Context, rule pricing.Rule) error {
+	if rule.Role != "" && rule.Role != pricing.RoleGuest {
+		_, err := users.GetRole(ctx, rule.Role, database)
+		if err != nil {
+			return err
+		}
+	}
+	if rule.ItemId != "" {
+		_, err := items.GetItemById(ctx, rule.ItemId, database)
+		if err != nil {
+			return err
+		}
+	}
+	if rule.CategoryId != "" {
+		_, err := items.GetCategoryById(ctx, rule.CategoryId, database)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pricingRuleError
+// Map errors from saving a pricing rule to a response
+func pricingRuleError(ctx context.Context, err error) (context.Context, any) {
+	if errors.Is(err, items.ErrNoSuchItem) || errors.Is(err, items.ErrNoSuchCategory) || errors.Is(err, users.ErrNoSuchRole) {
+		return errorWithContextAndDetail(ctx, http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, pricing.ErrNoSuchRule) {
+		return errorWithContextAndDetail(ctx, http.StatusNotFound, err.Error())
+	}
+	log.Println("Error while saving pricing rule", err)
+	return errorWithContext(ctx, http.StatusInternalServerError)
+}
+
+var addPricingRule handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[addPricingRuleRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	rule := req.Rule(uuid.New().String())
+	err = checkPricingRuleScope(r.Context(), rule)
+	if err == nil {
+		err = pricing.InsertNewRule(r.Context(), rule, database)
+	}
+	if err != nil {
+		return pricingRuleError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), rule
+}
+
+var updatePricingRule handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[updatePricingRuleRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	rule := req.Rule(req.Id)
+	err = checkPricingRuleScope(r.Context(), rule)
+	if err == nil {
+		err = pricing.UpdateRule(r.Context(), rule, database)
+	}
+	if err != nil {
+		return pricingRuleError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), rule
+}
+
+var deletePricingRule handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid pricing rule id, uuid expected")
+	}
+	err = pricing.DeleteRule(r.Context(), id.String(), database)
+	if errors.Is(err, pricing.ErrNoSuchRule) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while deleting pricing rule", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusNoContent), nil
+}
+
+var getRoles handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	roles, err := users.GetRoles(r.Context(), database)
+	if err != nil {
+		log.Println("Error while retrieving roles", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), roles
+}
+
+var getPermissions handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), users.Permissions
+}
+
+// roleError
+// Map errors from changing a role to a response
+func roleError(ctx context.Context, err error) (context.Context, any) {
+	switch {
+	case errors.Is(err, users.ErrNoSuchRole):
+		return errorWithContextAndDetail(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, users.ErrRoleExists), errors.Is(err, users.ErrBuiltinRole), errors.Is(err, users.ErrRoleInUse):
+		return errorWithContextAndDetail(ctx, http.StatusBadRequest, err.Error())
+	}
+	log.Println("Error while changing role", err)
+	return errorWithContext(ctx, http.StatusInternalServerError)
+}
+
+var addRole handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[roleRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	err = users.AddRole(r.Context(), req.Role(), database)
+	if err != nil {
+		return roleError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), req.Role()
+}
+
+// updateRole
+// Replace the permissions of a role. Permissions are checked on every request, so the change applies to users who
+// are logged in already.
+var updateRole handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[roleRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	err = users.UpdateRole(r.Context(), req.Role(), database)
+	if err != nil {
+		return roleError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), req.Role()
+}
+
+var deleteRole handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	err := users.DeleteRole(r.Context(), r.PathValue("name"), database)
+	if err != nil {
+		return roleError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusNoContent), nil
+}
+
 var getUsers handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
-	allUsers, err := users.GetAllUsers(r.Context(), database)
+	page, err := pagination.FromQuery(r.URL.Query(), users.Sorts, "username")
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	allUsers, next, err := users.GetUsers(r.Context(), r.URL.Query().Get("role"), page, database)
 	if err != nil {
 		log.Println("Error while retrieving users from database:", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
-	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), allUsers
+	return withNextPage(handlehttp.ContextWithStatus(r.Context(), http.StatusOK), r, next), allUsers
 }
 
 var getUsersWithNoneAuth handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
@@ -143,6 +622,7 @@ var registerWithPassword handlehttp.RequestHandler = func(r *http.Request) (cont
 		log.Println("Error while adding user to database:", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
+	sendEmailVerificationMail(user)
 
 	auth := users.AuthenticationData{
 		User: user.Id,
@@ -220,6 +700,9 @@ var loginWithPassword handlehttp.RequestHandler = func(r *http.Request) (context
 	if !users.VerifyPasswordHash(auth.Data, req.Password) {
 		return errorWithContext(r.Context(), http.StatusForbidden)
 	}
+	if user.Deactivated {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, users.ErrUserDeactivated.Error())
+	}
 	sess := session.CreateSession(user.Id, user.Role, auth.Type, config.SessionLifetime)
 	sessionStore.Store(sess)
 
@@ -238,6 +721,9 @@ var loginCash handlehttp.RequestHandler = func(r *http.Request) (context.Context
 		log.Println("error logging in with cash user:", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
+	if user.Deactivated {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, users.ErrUserDeactivated.Error())
+	}
 
 	sess := session.CreateSession(user.Id, "user", "cash", config.SessionLifetime)
 	sessionStore.Store(sess)
@@ -266,6 +752,9 @@ var loginNone handlehttp.RequestHandler = func(r *http.Request) (context.Context
 		log.Println("Could not get auth data", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
+	if user.Deactivated {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, users.ErrUserDeactivated.Error())
+	}
 
 	sess := session.CreateSession(user.Id, "user", auth.Type, config.SessionLifetime)
 	sessionStore.Store(sess)
@@ -297,6 +786,9 @@ var loginNFC handlehttp.RequestHandler = func(r *http.Request) (context.Context,
 		log.Println("Could not get auth data", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
+	if user.Deactivated {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, users.ErrUserDeactivated.Error())
+	}
 
 	sess := session.CreateSession(user.Id, "user", auth.Type, config.SessionLifetime)
 	sessionStore.Store(sess)
@@ -342,47 +834,380 @@ var buyItem handlehttp.RequestHandler = func(r *http.Request) (context.Context,
 		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
 	}
 
-	item, err := items.GetItemById(r.Context(), req.ItemId, database)
-	if err != nil {
-		log.Println("error getting item:", err)
-		return errorWithContext(r.Context(), http.StatusNotFound)
-	}
 	user, err := users.GetUserForId(r.Context(), s.UserId, database)
 	if err != nil {
 		log.Println("error getting user from session:", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
-	err = transactions.MakeTransaction(r.Context(), &user, &item, req.Amount, s.AuthBackend, database)
+	receipt, err := transactions.MakeTransaction(r.Context(), &user, req.CartLines(), s.AuthBackend, database)
+	if errors.Is(err, transactions.ErrNoSuchItem) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, transactions.ErrNotEnoughCredits) || errors.Is(err, transactions.ErrNotEnoughStock) ||
+		errors.Is(err, transactions.ErrItemArchived) {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		log.Println("error while performing transaction", err)
-		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
-	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), nil
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), receipt
 }
 
-var getTransactions handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
-	since := int64(0)
-	until := time.Now().Unix()
-	if r.URL.Query().Has("since") {
-		since, _ = strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+var returnEmpties handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	req, err := handlehttp.ReadValidBody[returnEmptiesRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	itemId, bottles := req.ItemId, req.Amount
+	if req.Barcode != "" {
+		item, multiplier, err := items.GetItemByBarcode(r.Context(), req.Barcode, database)
+		if errors.Is(err, items.ErrNoSuchItem) {
+			return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			log.Println("error while looking up barcode:", err)
+			return errorWithContext(r.Context(), http.StatusInternalServerError)
+		}
+		itemId, bottles = item.Id, req.Amount*multiplier
+	}
+	user, err := users.GetUserForId(r.Context(), s.UserId, database)
+	if err != nil {
+		log.Println("error getting user from session:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
-	if r.URL.Query().Has("until") {
-		until, _ = strconv.ParseInt(r.URL.Query().Get("until"), 10, 64)
+	entry, err := transactions.ReturnEmpties(r.Context(), &user, itemId, bottles, s.AuthBackend, database)
+	if errors.Is(err, transactions.ErrNoSuchItem) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, transactions.ErrNoDeposit) {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
 	}
-	transac, err := transactions.GetTransactionsSince(r.Context(), since, until, database)
 	if err != nil {
-		log.Println("error while retrieving all transactions:", err)
+		log.Println("error while returning empties:", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
-
-	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), transac
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), entry
 }
 
-var getItem handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
-	idString := strings.TrimPrefix(r.URL.Path, "/items/")
-	id, err := uuid.Parse(idString)
+var getDepositLiability handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	liability, err := transactions.GetDepositLiability(r.Context(), database)
 	if err != nil {
-		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item id, uuid expected")
+		log.Println("error while calculating the deposit liability:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), liability
+}
+
+var getMarginReport handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	// only the time range of the transaction filter is used
+	filter, err := readTransactionFilter(r.URL.Query())
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	report, err := reports.GetMargins(r.Context(), filter.Since, filter.Until, database)
+	if err != nil {
+		log.Println("error while calculating margins:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), report
+}
+
+var getInventoryValue handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	inventory, err := reports.GetInventoryValue(r.Context(), database)
+	if err != nil {
+		log.Println("error while calculating the inventory value:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), inventory
+}
+
+var getRevenueReport handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	// only the time range of the transaction filter is used
+	filter, err := readTransactionFilter(r.URL.Query())
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	report, err := reports.GetRevenueByCategory(r.Context(), filter.Since, filter.Until, database)
+	if err != nil {
+		log.Println("error while calculating the revenue:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), report
+}
+
+// readTransactionFilter
+// Read the filter for listing transactions from the query parameters
+func readTransactionFilter(query url.Values) (transactions.Filter, error) {
+	filter := transactions.Filter{
+		AuthBackend: query.Get("authBackend"),
+		Type:        query.Get("type"),
+		Until:       time.Now().Unix() + 1,
+	}
+	var err error
+	if query.Has("since") {
+		filter.Since, err = strconv.ParseInt(query.Get("since"), 10, 64)
+		if err != nil {
+			return filter, errors.New("since must be a unix timestamp")
+		}
+	}
+	if query.Has("until") {
+		filter.Until, err = strconv.ParseInt(query.Get("until"), 10, 64)
+		if err != nil {
+			return filter, errors.New("until must be a unix timestamp")
+		}
+	}
+	if query.Has("user") {
+		id, err := uuid.Parse(query.Get("user"))
+		if err != nil {
+			return filter, errors.New("invalid user id, uuid expected")
+		}
+		filter.UserId = id.String()
+	}
+	if query.Has("item") {
+		id, err := uuid.Parse(query.Get("item"))
+		if err != nil {
+			return filter, errors.New("invalid item id, uuid expected")
+		}
+		filter.ItemId = id.String()
+	}
+	return filter, nil
+}
+
+var getTransactions handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	filter, err := readTransactionFilter(r.URL.Query())
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	page, err := pagination.FromQuery(r.URL.Query(), transactions.Sorts, "-timestamp")
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	transac, next, err := transactions.GetTransactions(r.Context(), filter, page, database)
+	if err != nil {
+		log.Println("error while retrieving all transactions:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+
+	return withNextPage(handlehttp.ContextWithStatus(r.Context(), http.StatusOK), r, next), transac
+}
+
+var getMyTransactions handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	filter, err := readTransactionFilter(r.URL.Query())
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	// users only ever see their own transactions
+	filter.UserId = s.UserId
+	page, err := pagination.FromQuery(r.URL.Query(), transactions.Sorts, "-timestamp")
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+
+	history, next, err := transactions.GetTransactions(r.Context(), filter, page, database)
+	if err != nil {
+		log.Println("error while retrieving transactions of user:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+
+	return withNextPage(handlehttp.ContextWithStatus(r.Context(), http.StatusOK), r, next), history
+}
+
+var getProfile handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	user, err := users.GetUserForId(r.Context(), s.UserId, database)
+	if err != nil {
+		log.Println("Error while retrieving user", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return profileOf(r.Context(), user)
+}
+
+func profileOf(ctx context.Context, user users.User) (context.Context, any) {
+	pendingEmail, err := users.GetPendingEmail(ctx, user.Id, database)
+	if err != nil {
+		log.Println("Error while retrieving pending email", err)
+		return errorWithContext(ctx, http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(ctx, http.StatusOK), profileResponse{User: user, PendingEmail: pendingEmail}
+}
+
+// passwordMatches
+// Check the password of a user who is logged in already, e.g. before changing their login details. Users without a
+// password never match.
+func passwordMatches(ctx context.Context, userId, password string) bool {
+	auth, err := users.GetAuthForUser(ctx, userId, "password", database)
+	if err != nil {
+		log.Println("Could not get auth data", err)
+		return false
+	}
+	return users.VerifyPasswordHash(auth.Data, password)
+}
+
+// changePassword
+// Set a new password for the logged-in user. All their sessions end, the new session is returned.
+var changePassword handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	if s.UserId == users.CashUserId {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "the cash user can't be changed")
+	}
+	req, err := handlehttp.ReadValidBody[changePasswordRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	if !passwordMatches(r.Context(), s.UserId, req.CurrentPassword) {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, "the current password is wrong")
+	}
+	auth := users.AuthenticationData{
+		User: s.UserId,
+		Type: "password",
+		Data: users.CalculatePasswordHash(req.NewPassword),
+	}
+	err = users.AddAuthentication(r.Context(), auth, database)
+	if err != nil {
+		log.Println("Error saving auth:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+
+	// whoever knew the old password is logged out
+	sessionStore.DeleteForUser(s.UserId)
+	sess := session.CreateSession(s.UserId, s.Role, s.AuthBackend, config.SessionLifetime)
+	sessionStore.Store(sess)
+
+	ctx := handlehttp.ContextWithSession(r.Context(), sess)
+	ctx = handlehttp.ContextWithStatus(ctx, http.StatusOK)
+	return ctx, loginResponse{
+		Token:      sess.Id,
+		ValidUntil: sess.NotValidAfter,
+	}
+}
+
+// updateProfile
+// Apply a change to the logged-in user, unless they are the cash user
+func updateProfile(r *http.Request, change func(user *users.User)) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	if s.UserId == users.CashUserId {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "the cash user can't be changed")
+	}
+	user, err := users.GetUserForId(r.Context(), s.UserId, database)
+	if err != nil {
+		log.Println("Error while retrieving user", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	change(&user)
+	err = users.UpdateUserDetails(r.Context(), &user, database)
+	if errors.Is(err, users.ErrUsernameTaken) {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while updating user", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return profileOf(r.Context(), user)
+}
+
+// changeEmail
+// Mail a token to the new address of the logged-in user. The address only replaces the current one once the token has
+// been sent back to /auth/verify-email.
+var changeEmail handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[changeEmailRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	if s.UserId == users.CashUserId {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "the cash user can't be changed")
+	}
+	user, err := users.GetUserForId(r.Context(), s.UserId, database)
+	if err != nil {
+		log.Println("Error while retrieving user", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	// users logging in without a password confirm the change with the token mailed to the new address alone
+	auth, err := users.GetAuthForUser(r.Context(), user.Id, "password", database)
+	if err != nil && !errors.Is(err, users.ErrNoSuchAuth) {
+		log.Println("Could not get auth data", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	if err == nil && !users.VerifyPasswordHash(auth.Data, req.Password) {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, "the password is wrong")
+	}
+	// submitting the current address again sends a new token, in case the last one expired
+	if req.Email == user.Email {
+		sendEmailVerificationMail(user)
+		return profileOf(r.Context(), user)
+	}
+	token, err := users.AddEmailChangeToken(r.Context(), user.Id, req.Email, database)
+	if err != nil {
+		log.Println("Error while saving email change token", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	go func() {
+		err := mailing.SendEmailVerificationMail(user.Username, token.Email, token.Token)
+		if err != nil {
+			log.Println("Error while trying to send email change mail:", err)
+		}
+	}()
+	return profileOf(r.Context(), user)
+}
+
+var changeUsername handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[changeUsernameRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	return updateProfile(r, func(user *users.User) {
+		user.Username = req.Username
+	})
+}
+
+func reversalError(ctx context.Context, err error) (context.Context, any) {
+	switch {
+	case errors.Is(err, transactions.ErrNoSuchTransaction):
+		return errorWithContextAndDetail(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, transactions.ErrAlreadyReversed):
+		return errorWithContextAndDetail(ctx, http.StatusConflict, err.Error())
+	case errors.Is(err, transactions.ErrNotReversible), errors.Is(err, transactions.ErrUndoWindowExceeded):
+		return errorWithContextAndDetail(ctx, http.StatusBadRequest, err.Error())
+	}
+	log.Println("error while reversing transaction:", err)
+	return errorWithContext(ctx, http.StatusInternalServerError)
+}
+
+var undoTransaction handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid transaction id, uuid expected")
+	}
+	reversal, err := transactions.UndoTransaction(r.Context(), id.String(), s.UserId,
+		time.Duration(config.UndoWindow)*time.Second, s.AuthBackend, database)
+	if err != nil {
+		return reversalError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), reversal
+}
+
+var refundTransaction handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid transaction id, uuid expected")
+	}
+	reversal, err := transactions.RefundTransaction(r.Context(), id.String(), s.AuthBackend, database)
+	if err != nil {
+		return reversalError(r.Context(), err)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), reversal
+}
+
+var getItem handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	idString := strings.TrimPrefix(r.URL.Path, "/items/")
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item id, uuid expected")
 	}
 	item, err := items.GetItemById(r.Context(), id.String(), database)
 	if err != nil {
@@ -392,17 +1217,109 @@ var getItem handlehttp.RequestHandler = func(r *http.Request) (context.Context,
 	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), item
 }
 
+// imageCacheControl allows caching images for a short time, afterward they are revalidated using their ETag
+const imageCacheControl = "public, max-age=300"
+
+var getItemImage handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	if r.PathValue("resource") != "image" {
+		return errorWithContext(r.Context(), http.StatusNotFound)
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item id, uuid expected")
+	}
+	var image items.Image
+	if r.URL.Query().Has("size") {
+		size, convErr := strconv.Atoi(r.URL.Query().Get("size"))
+		if convErr != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, items.ErrInvalidImageSize.Error())
+		}
+		image, err = items.GetThumbnail(r.Context(), id.String(), size, database)
+	} else {
+		image, err = items.GetImage(r.Context(), id.String(), database)
+	}
+	switch {
+	case errors.Is(err, items.ErrInvalidImageSize):
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	case errors.Is(err, items.ErrNoSuchItem), errors.Is(err, items.ErrNoImage):
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	case err != nil:
+		log.Println("Error while retrieving item image:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+
+	hash := sha256.Sum256(image.Data)
+	file := handlehttp.File{
+		ContentType:  image.ContentType,
+		Data:         image.Data,
+		ETag:         `"` + hex.EncodeToString(hash[:16]) + `"`,
+		CacheControl: imageCacheControl,
+	}
+	if match := r.Header.Get("If-None-Match"); match == "*" || strings.Contains(match, file.ETag) {
+		return handlehttp.ContextWithStatus(r.Context(), http.StatusNotModified), file
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), file
+}
+
 var getItemByBarcode handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
-	barcodeString := strings.TrimPrefix(r.URL.Path, "/items/barcode/")
-	if !regexp.MustCompile("^[0-9]+$").MatchString(barcodeString) {
+	item, multiplier, err := items.ScanBarcode(r.Context(), r.PathValue("id"), config.AlphanumericBarcodes, database)
+	if errors.Is(err, items.ErrInvalidBarcode) {
 		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item barcode")
 	}
-	item, err := items.GetItemByBarcode(r.Context(), barcodeString, database)
 	if err != nil {
 		return errorWithContext(r.Context(), http.StatusNotFound)
 	}
 
-	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), item
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), scannedItemResponse{Item: item, Multiplier: multiplier}
+}
+
+var addItemBarcode handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item id, uuid expected")
+	}
+	req, err := handlehttp.ReadValidBody[addBarcodeRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	_, err = items.GetItemById(r.Context(), id.String(), database)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	err = items.AddBarcode(r.Context(), id.String(), items.Barcode{Code: req.Barcode, Multiplier: req.Multiplier}, database)
+	if errors.Is(err, items.ErrBarcodeInUse) {
+		return errorWithContextAndDetail(r.Context(), http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while adding barcode", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	item, err := items.GetItemById(r.Context(), id.String(), database)
+	if err != nil {
+		return errorWithContext(r.Context(), http.StatusNotFound)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusCreated), item
+}
+
+var removeItemBarcode handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid item id, uuid expected")
+	}
+	barcode := r.PathValue("barcode")
+	// barcodes which are no longer valid, e.g. after disabling alphanumeric codes, can still be removed as they are
+	if normalized, err := items.NormalizeBarcode(barcode, config.AlphanumericBarcodes); err == nil {
+		barcode = normalized
+	}
+	err = items.RemoveBarcode(r.Context(), id.String(), barcode, database)
+	if errors.Is(err, items.ErrNoSuchBarcode) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while removing barcode", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusNoContent), nil
 }
 
 var getUser handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
@@ -418,6 +1335,148 @@ var getUser handlehttp.RequestHandler = func(r *http.Request) (context.Context,
 	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), user
 }
 
+var updateUser handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[updateUserRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	if req.Id == users.CashUserId {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "the cash user can't be changed")
+	}
+	user, err := users.GetUserForId(r.Context(), req.Id, database)
+	if errors.Is(err, users.ErrNoSuchUser) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while retrieving user", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	if !mayManageUser(s, user) {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, onlyAdminsManageAdmins)
+	}
+	roleChanged := req.Role != user.Role
+	// admins could otherwise lock themselves out
+	if roleChanged && user.Id == s.UserId {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "you can't change your own role")
+	}
+	// admins have every permission, so only they may make others admins
+	if roleChanged && req.Role == users.RoleAdmin && s.Role != users.RoleAdmin {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, "only admins can grant the admin role")
+	}
+	if roleChanged {
+		_, err = users.GetRole(r.Context(), req.Role, database)
+		if errors.Is(err, users.ErrNoSuchRole) {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+		}
+		if err != nil {
+			log.Println("Error while retrieving role", err)
+			return errorWithContext(r.Context(), http.StatusInternalServerError)
+		}
+	}
+	emailChanged := req.Email != user.Email
+	user.Username, user.Email, user.Role = req.Username, req.Email, req.Role
+	err = users.UpdateUserDetails(r.Context(), &user, database)
+	switch {
+	case errors.Is(err, users.ErrUsernameTaken):
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	case errors.Is(err, users.ErrNoSuchUser):
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	case err != nil:
+		log.Println("Error while updating user", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	// sessions keep the role they have been created with, so the user has to log in again
+	if roleChanged {
+		sessionStore.DeleteForUser(user.Id)
+	}
+	if emailChanged {
+		sendEmailVerificationMail(user)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), user
+}
+
+// setUserDeactivated
+// Deactivate or reactivate a user. Deactivating a user ends all their sessions.
+func setUserDeactivated(deactivated bool) handlehttp.RequestHandler {
+	return func(r *http.Request) (context.Context, any) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid user id, uuid expected")
+		}
+		s, _ := handlehttp.ContextGetSession(r.Context())
+		if deactivated && id.String() == s.UserId {
+			return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "you can't deactivate yourself")
+		}
+		user, err := users.GetUserForId(r.Context(), id.String(), database)
+		if errors.Is(err, users.ErrNoSuchUser) {
+			return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			log.Println("Error while retrieving user", err)
+			return errorWithContext(r.Context(), http.StatusInternalServerError)
+		}
+		if !mayManageUser(s, user) {
+			return errorWithContextAndDetail(r.Context(), http.StatusForbidden, onlyAdminsManageAdmins)
+		}
+		err = users.SetDeactivated(r.Context(), id.String(), deactivated, database)
+		if errors.Is(err, users.ErrNoSuchUser) {
+			return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			log.Println("Error while deactivating user", err)
+			return errorWithContext(r.Context(), http.StatusInternalServerError)
+		}
+		if deactivated {
+			sessionStore.DeleteForUser(id.String())
+		}
+		user, err = users.GetUserForId(r.Context(), id.String(), database)
+		if err != nil {
+			return errorWithContext(r.Context(), http.StatusNotFound)
+		}
+		return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), user
+	}
+}
+
+// adjustCredit
+// Correct the credit of a user, e.g. for cash put into the box without using the cash login. The admin and their
+// reason are recorded in the ledger.
+var adjustCredit handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "invalid user id, uuid expected")
+	}
+	if id.String() == users.CashUserId {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "the credit of the cash user is not tracked")
+	}
+	req, err := handlehttp.ReadValidBody[adjustCreditRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	user, err := users.GetUserForId(r.Context(), id.String(), database)
+	if errors.Is(err, users.ErrNoSuchUser) {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Println("Error while retrieving user", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	s, _ := handlehttp.ContextGetSession(r.Context())
+	if !mayManageUser(s, user) {
+		return errorWithContextAndDetail(r.Context(), http.StatusForbidden, onlyAdminsManageAdmins)
+	}
+	entry, err := transactions.ChangeCredit(r.Context(), id.String(), req.Diff, transactions.TypeCorrection,
+		s.AuthBackend, s.UserId, req.Reason, database)
+	if errors.Is(err, users.ErrInsufficientCredit) {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "the credit must not become negative")
+	}
+	if err != nil {
+		log.Println("Error adjusting credit:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), entry
+}
+
 var changeCredit handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
 	token, hasToken := handlehttp.ContextGetSessionToken(r.Context())
 	if !hasToken {
@@ -427,27 +1486,93 @@ var changeCredit handlehttp.RequestHandler = func(r *http.Request) (context.Cont
 	if err != nil || sess.AuthBackend != "password" {
 		return errorWithContext(r.Context(), http.StatusUnauthorized)
 	}
-	user, err := users.GetUserForId(r.Context(), sess.UserId, database)
+	req, err := handlehttp.ReadValidBody[changeCreditRequest](r)
 	if err != nil {
-		log.Println("Error getting user:", err)
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+
+	entryType := transactions.TypeDeposit
+	if req.Diff < 0 {
+		entryType = transactions.TypeWithdrawal
+	}
+	entry, err := transactions.ChangeCredit(r.Context(), sess.UserId, req.Diff, entryType, sess.AuthBackend, "", "", database)
+	if errors.Is(err, users.ErrInsufficientCredit) {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "lending money is not allowed")
+	}
+	if err != nil {
+		log.Println("Error changing credit:", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
-	req, err := handlehttp.ReadValidBody[changeCreditRequest](r)
+
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), entry
+}
+
+var transferCredit handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	token, hasToken := handlehttp.ContextGetSessionToken(r.Context())
+	if !hasToken {
+		return errorWithContext(r.Context(), http.StatusUnauthorized)
+	}
+	sess, err := sessionStore.Get(token)
+	if err != nil || sess.AuthBackend != "password" {
+		return errorWithContext(r.Context(), http.StatusUnauthorized)
+	}
+	req, err := handlehttp.ReadValidBody[transferCreditRequest](r)
 	if err != nil {
 		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
 	}
+	recipient, err := users.GetUserForUsername(r.Context(), req.Username, database)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusNotFound, "no such user")
+	}
 
-	if user.Credit+req.Diff < 0 {
-		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "lending money is not allowed")
+	entry, err := transactions.Transfer(r.Context(), sess.UserId, recipient.Id, req.Amount, sess.AuthBackend, database)
+	switch {
+	case errors.Is(err, users.ErrInsufficientCredit):
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, "not enough credits")
+	case errors.Is(err, transactions.ErrSelfTransfer), errors.Is(err, transactions.ErrCashUserTransfer):
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	case err != nil:
+		log.Println("Error transferring credit:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
-	user.Credit += req.Diff
-	err = users.UpdateUser(r.Context(), &user, database)
+
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), entry
+}
+
+var checkLedger handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	mismatches, err := transactions.CheckLedger(r.Context(), database)
 	if err != nil {
-		log.Println("Error updating user in database:", err)
+		log.Println("Error checking ledger:", err)
 		return errorWithContext(r.Context(), http.StatusInternalServerError)
 	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), mismatches
+}
+
+// sendEmailVerificationMail
+// Mail a verification token to the user in the background, if their address has not been verified yet
+func sendEmailVerificationMail(user users.User) {
+	go func() {
+		err := users.SendEmailVerificationMail(context.Background(), &user, database)
+		if err != nil {
+			log.Println("Error while trying to send email verification mail:", err)
+		}
+	}()
+}
 
-	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), nil
+var verifyEmail handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
+	req, err := handlehttp.ReadValidBody[verifyEmailRequest](r)
+	if err != nil {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	user, err := users.VerifyEmail(r.Context(), req.Token, database)
+	if errors.Is(err, users.ErrInvalidVerificationToken) {
+		return errorWithContextAndDetail(r.Context(), http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		log.Println("Error verifying email:", err)
+		return errorWithContext(r.Context(), http.StatusInternalServerError)
+	}
+	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), user
 }
 
 var requestPasswordReset handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
@@ -458,7 +1583,7 @@ var requestPasswordReset handlehttp.RequestHandler = func(r *http.Request) (cont
 
 	// doing things async, so response timing is not affected by the process.
 	go func() {
-		err := users.SendPasswordResetMail(req.Username, database)
+		err := users.SendPasswordResetMail(req.Username, config.ResetVerifiedOnly, database)
 		if err != nil {
 			log.Println("Error while trying to send password reset mail:", err)
 		}
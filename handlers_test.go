@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Port39/go-drink/handlehttp"
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/session"
+	"github.com/Port39/go-drink/testutils"
+	"github.com/Port39/go-drink/users"
+)
+
+// setupHandlerTest
+// Let the handlers use a fresh database and session store for the duration of the test
+func setupHandlerTest(t *testing.T) {
+	database = testutils.GetMigratedDb(t)
+	sessionStore = session.NewMemoryStore()
+	t.Cleanup(func() { testutils.FailOnError(database.Close(), t) })
+}
+
+// callHandler
+// Run the handler for the request as the given session, and return the status it responds with
+func callHandler(t *testing.T, handler handlehttp.RequestHandler, r *http.Request, s session.Session) (int, any) {
+	t.Helper()
+	ctx, result := handler(r.WithContext(handlehttp.ContextWithSession(r.Context(), s)))
+	status, ok := handlehttp.ContextGetStatus(ctx)
+	if !ok {
+		t.Fatal("the handler did not set a status")
+	}
+	return status, result
+}
+
+func TestRemoveItemBarcode(t *testing.T) {
+	setupHandlerTest(t)
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	mate := items.Item{Id: "00000000-0000-0000-0000-00000000000a", Name: "Mate", Price: 150,
+		Barcodes: []items.Barcode{{Code: "4029764001807", Multiplier: 1}}}
+	testutils.FailOnError(items.InsertNewItem(ctx, &mate, database), t)
+	admin := session.Session{UserId: "00000000-0000-0000-0000-000000000001", Role: "admin"}
+
+	remove := func(barcode string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/items/"+mate.Id+"/barcodes/"+barcode, nil)
+		r.SetPathValue("id", mate.Id)
+		r.SetPathValue("barcode", barcode)
+		status, _ := callHandler(t, removeItemBarcode, r, admin)
+		return status
+	}
+	testutils.ExpectEqual(remove("4029764001999"), http.StatusNotFound, t)
+	testutils.ExpectEqual(remove("4029764001807"), http.StatusNoContent, t)
+	testutils.ExpectEqual(remove("4029764001807"), http.StatusNotFound, t)
+}
+
+func jsonRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestManageAdminAccounts(t *testing.T) {
+	setupHandlerTest(t)
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	admin := users.User{Id: "00000000-0000-0000-0000-000000000001", Username: "admin", Email: "admin@godrink.test",
+		Role: users.RoleAdmin, Verified: true}
+	member := users.User{Id: "00000000-0000-0000-0000-000000000002", Username: "member", Email: "member@godrink.test",
+		Role: users.RoleUser, Verified: true}
+	for _, user := range []users.User{admin, member} {
+		testutils.FailOnError(users.AddUser(ctx, user, database), t)
+	}
+	manager := users.Role{Name: "manager", Permissions: []string{users.PermissionUsersWrite, users.PermissionCreditAdjust}}
+	testutils.FailOnError(users.AddRole(ctx, manager, database), t)
+	managerSession := session.Session{UserId: "00000000-0000-0000-0000-000000000003", Role: manager.Name}
+	adminSession := session.Session{UserId: "00000000-0000-0000-0000-000000000004", Role: users.RoleAdmin}
+
+	update := func(user users.User, s session.Session) int {
+		r := jsonRequest(http.MethodPost, "/users/update", `{"id":"`+user.Id+`","username":"`+user.Username+
+			`","email":"`+user.Email+`","role":"`+user.Role+`"}`)
+		status, _ := callHandler(t, updateUser, r, s)
+		return status
+	}
+	setDeactivated := func(user users.User, deactivated bool, s session.Session) int {
+		r := httptest.NewRequest(http.MethodPost, "/users/"+user.Id+"/deactivate", nil)
+		r.SetPathValue("id", user.Id)
+		status, _ := callHandler(t, setUserDeactivated(deactivated), r, s)
+		return status
+	}
+	adjust := func(user users.User, s session.Session) int {
+		r := jsonRequest(http.MethodPost, "/users/"+user.Id+"/credit", `{"diff":100,"reason":"found in the box"}`)
+		r.SetPathValue("id", user.Id)
+		status, _ := callHandler(t, adjustCredit, r, s)
+		return status
+	}
+
+	// anyone allowed to manage users can manage users who aren't admins
+	renamed := member
+	renamed.Username = "renamed"
+	testutils.ExpectEqual(update(renamed, managerSession), http.StatusOK, t)
+	testutils.ExpectEqual(setDeactivated(member, true, managerSession), http.StatusOK, t)
+	testutils.ExpectEqual(setDeactivated(member, false, managerSession), http.StatusOK, t)
+	testutils.ExpectEqual(adjust(member, managerSession), http.StatusOK, t)
+
+	// but only admins can change admin accounts in any way, or make someone an admin
+	renamed = admin
+	renamed.Username = "renamed_admin"
+	testutils.ExpectEqual(update(renamed, managerSession), http.StatusForbidden, t)
+	promoted := member
+	promoted.Username = "renamed"
+	promoted.Role = users.RoleAdmin
+	testutils.ExpectEqual(update(promoted, managerSession), http.StatusForbidden, t)
+	testutils.ExpectEqual(setDeactivated(admin, true, managerSession), http.StatusForbidden, t)
+	testutils.ExpectEqual(setDeactivated(admin, false, managerSession), http.StatusForbidden, t)
+	testutils.ExpectEqual(adjust(admin, managerSession), http.StatusForbidden, t)
+	retrieved, err := users.GetUserForId(ctx, admin.Id, database)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved, admin, t)
+
+	testutils.ExpectEqual(update(renamed, adminSession), http.StatusOK, t)
+	testutils.ExpectEqual(setDeactivated(admin, true, adminSession), http.StatusOK, t)
+	testutils.ExpectEqual(adjust(admin, adminSession), http.StatusOK, t)
+}
+
+func TestChangeEmail(t *testing.T) {
+	setupHandlerTest(t)
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	withPassword := users.User{Id: "00000000-0000-0000-0000-000000000001", Username: "password",
+		Email: "password@godrink.test", Role: users.RoleUser, Verified: true}
+	withoutPassword := users.User{Id: "00000000-0000-0000-0000-000000000002", Username: "nfc",
+		Email: "nfc@godrink.test", Role: users.RoleUser, Verified: true}
+	for _, user := range []users.User{withPassword, withoutPassword} {
+		testutils.FailOnError(users.AddUser(ctx, user, database), t)
+	}
+	testutils.FailOnError(users.AddAuthentication(ctx, users.AuthenticationData{User: withPassword.Id, Type: "password",
+		Data: users.CalculatePasswordHash("correct horse battery staple")}, database), t)
+
+	change := func(user users.User, body string) (int, any) {
+		r := jsonRequest(http.MethodPost, "/me/email", body)
+		return callHandler(t, changeEmail, r, session.Session{UserId: user.Id, Role: user.Role})
+	}
+	status, _ := change(withPassword, `{"email":"new@godrink.test","password":"wrong"}`)
+	testutils.ExpectEqual(status, http.StatusForbidden, t)
+	status, result := change(withPassword, `{"email":"new@godrink.test","password":"correct horse battery staple"}`)
+	testutils.ExpectEqual(status, http.StatusOK, t)
+	testutils.ExpectEqual(result.(profileResponse).PendingEmail, "new@godrink.test", t)
+	status, result = change(withoutPassword, `{"email":"new-nfc@godrink.test"}`)
+	testutils.ExpectEqual(status, http.StatusOK, t)
+	testutils.ExpectEqual(result.(profileResponse).PendingEmail, "new-nfc@godrink.test", t)
+
+	// the current address is kept until the token mailed to the new one is sent back
+	retrieved, err := users.GetUserForId(ctx, withoutPassword.Id, database)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.Email, withoutPassword.Email, t)
+	var token string
+	testutils.FailOnError(database.QueryRowContext(ctx, `SELECT token FROM email_verification WHERE user_id = $1`,
+		withoutPassword.Id).Scan(&token), t)
+	status, _ = callHandler(t, verifyEmail, jsonRequest(http.MethodPost, "/auth/verify-email", `{"token":"`+token+`"}`),
+		session.Session{})
+	testutils.ExpectEqual(status, http.StatusOK, t)
+	retrieved, err = users.GetUserForId(ctx, withoutPassword.Id, database)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.Email, "new-nfc@godrink.test", t)
+	testutils.ExpectSuccess(retrieved.Verified, t)
+}
+
+func TestRegisterWithPassword_Invalid(t *testing.T) {
+	setupHandlerTest(t)
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	// the body used to be accepted without its Validate method ever being called
+	r := jsonRequest(http.MethodPost, "/register/password",
+		`{"username":"not a valid username","email":"not an email","password":"`+securePassword+`"}`)
+	status, _ := callHandler(t, registerWithPassword, r, session.Session{})
+	testutils.ExpectEqual(status, http.StatusBadRequest, t)
+	_, err := users.GetUserForUsername(ctx, "not a valid username", database)
+	testutils.ExpectError(err, t)
+}
+
+func TestGetItems_IncludeArchived(t *testing.T) {
+	setupHandlerTest(t)
+
+	// listing items needs no login, but listing archived ones does
+	r := httptest.NewRequest(http.MethodGet, "/items?includeArchived=true", nil)
+	ctx, _ := getItems(r)
+	status, _ := handlehttp.ContextGetStatus(ctx)
+	testutils.ExpectEqual(status, http.StatusForbidden, t)
+
+	status, _ = callHandler(t, getItems, httptest.NewRequest(http.MethodGet, "/items?includeArchived=true", nil),
+		session.Session{UserId: "00000000-0000-0000-0000-000000000001", Role: users.RoleUser})
+	testutils.ExpectEqual(status, http.StatusForbidden, t)
+	status, _ = callHandler(t, getItems, httptest.NewRequest(http.MethodGet, "/items?includeArchived=true", nil),
+		session.Session{UserId: "00000000-0000-0000-0000-000000000002", Role: users.RoleAdmin})
+	testutils.ExpectEqual(status, http.StatusOK, t)
+}
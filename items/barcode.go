@@ -0,0 +1,169 @@
+package items
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+)
+
+var (
+	ErrNoSuchBarcode  = errors.New("no such barcode")
+	ErrBarcodeInUse   = errors.New("the barcode belongs to another item")
+	ErrInvalidBarcode = errors.New("invalid barcode")
+)
+
+var (
+	numericBarcodeRegex      = regexp.MustCompile(`^[0-9]+$`)
+	alphanumericBarcodeRegex = regexp.MustCompile(`^[!-~]{1,128}$`)
+)
+
+// NormalizeBarcode
+// Check the barcode and bring it into the form it is stored in. Numeric codes must be valid EAN-8, EAN-13 or UPC-A
+// codes, UPC-A codes are turned into the equivalent EAN-13 code, so scanners find the same item in either mode. Other
+// codes, e.g. from Code128 labels or QR codes, are only allowed if requested, and consist of printable ASCII characters
+// without spaces.
+func NormalizeBarcode(code string, allowAlphanumeric bool) (string, error) {
+	if numericBarcodeRegex.MatchString(code) {
+		if len(code) == 12 {
+			code = "0" + code
+		}
+		if len(code) == 8 || len(code) == 13 {
+			if !hasValidCheckDigit(code) {
+				return "", ErrInvalidBarcode
+			}
+			return code, nil
+		}
+	}
+	if allowAlphanumeric && alphanumericBarcodeRegex.MatchString(code) {
+		return code, nil
+	}
+	return "", ErrInvalidBarcode
+}
+
+// hasValidCheckDigit
+// Verify the last digit of an EAN code. Starting from the right, the other digits are weighted with 3 and 1 in turns.
+func hasValidCheckDigit(code string) bool {
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		digit := int(code[i] - '0')
+		if (len(code)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(code[len(code)-1]-'0')
+}
+
+// Barcode
+// A barcode of an item. Scanning it stands for multiplier units of the item, e.g. 20 for a crate.
+type Barcode struct {
+	Code       string `json:"barcode"`
+	Multiplier int    `json:"multiplier"`
+}
+
+// GetItemByBarcode
+// Get the item with the barcode, together with the multiplier of the barcode. Archived items are ignored, so their
+// barcodes can be reused.
+func GetItemByBarcode(ctx context.Context, code string, db *sql.DB) (Item, int, error) {
+	var itemId string
+	var multiplier int
+	err := db.QueryRowContext(ctx, `SELECT item_id, multiplier FROM item_barcodes WHERE barcode = $1`, code).
+		Scan(&itemId, &multiplier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, 0, ErrNoSuchItem
+	}
+	if err != nil {
+		return Item{}, 0, err
+	}
+	item, err := getItemWhere(ctx, db, "items.id = $1 AND NOT items.archived", itemId)
+	return item, multiplier, err
+}
+
+// ScanBarcode
+// Get the item with the scanned barcode, like GetItemByBarcode, after normalizing the code. Codes which are not valid
+// anymore are still found if they have been stored as they are, e.g. before barcodes were validated.
+func ScanBarcode(ctx context.Context, code string, allowAlphanumeric bool, db *sql.DB) (Item, int, error) {
+	normalized, err := NormalizeBarcode(code, allowAlphanumeric)
+	if err == nil {
+		return GetItemByBarcode(ctx, normalized, db)
+	}
+	item, multiplier, err := GetItemByBarcode(ctx, code, db)
+	if errors.Is(err, ErrNoSuchItem) {
+		return Item{}, 0, ErrInvalidBarcode
+	}
+	return item, multiplier, err
+}
+
+// AddBarcode
+// Add the barcode to the item, or change its multiplier if the item already has it. Barcodes of archived items are
+// moved to the new item.
+func AddBarcode(ctx context.Context, itemId string, barcode Barcode, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = addBarcodeWithTransaction(ctx, itemId, barcode, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func addBarcodeWithTransaction(ctx context.Context, itemId string, barcode Barcode, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM item_barcodes WHERE barcode = $1
+		AND item_id IN (SELECT id FROM items WHERE archived)`, barcode.Code)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `INSERT INTO item_barcodes (barcode, item_id, multiplier) VALUES ($1, $2, $3)
+		ON CONFLICT (barcode) DO UPDATE SET multiplier = excluded.multiplier WHERE item_barcodes.item_id = excluded.item_id`,
+		barcode.Code, itemId, barcode.Multiplier)
+	if err != nil {
+		return err
+	}
+	changed, err := result.RowsAffected()
+	if err == nil && changed == 0 {
+		return ErrBarcodeInUse
+	}
+	return err
+}
+
+// RemoveBarcode
+// Remove the barcode from the item
+func RemoveBarcode(ctx context.Context, itemId string, code string, db *sql.DB) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM item_barcodes WHERE barcode = $1 AND item_id = $2`, code, itemId)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err == nil && deleted == 0 {
+		return ErrNoSuchBarcode
+	}
+	return err
+}
+
+// loadBarcodes
+// Add the barcodes to the items
+func loadBarcodes(ctx context.Context, q queryer, items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	in, args, indices := itemIdsIn(items)
+	result, err := q.QueryContext(ctx, `SELECT item_id, barcode, multiplier FROM item_barcodes WHERE item_id IN `+in+
+		` ORDER BY multiplier, barcode`, args...)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	for result.Next() {
+		var itemId string
+		var barcode Barcode
+		err = result.Scan(&itemId, &barcode.Code, &barcode.Multiplier)
+		if err != nil {
+			return err
+		}
+		items[indices[itemId]].Barcodes = append(items[indices[itemId]].Barcodes, barcode)
+	}
+	return result.Err()
+}
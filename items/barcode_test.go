@@ -0,0 +1,105 @@
+package items
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestNormalizeBarcode(t *testing.T) {
+	for _, code := range []string{"4029764001807", "96385074"} {
+		normalized, err := NormalizeBarcode(code, false)
+		testutils.FailOnError(err, t)
+		testutils.ExpectEqual(normalized, code, t)
+	}
+	// UPC-A codes are stored as EAN-13
+	normalized, err := NormalizeBarcode("036000291452", false)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(normalized, "0036000291452", t)
+
+	for _, code := range []string{"4029764001808", "96385075", "036000291453", "12345", "", "GD-0001"} {
+		_, err = NormalizeBarcode(code, false)
+		testutils.ExpectSuccess(errors.Is(err, ErrInvalidBarcode), t)
+	}
+
+	// alphanumeric codes are only allowed if requested, but EAN codes are still verified
+	normalized, err = NormalizeBarcode("GD-0001", true)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(normalized, "GD-0001", t)
+	for _, code := range []string{"4029764001808", "with space", strings.Repeat("x", 129)} {
+		_, err = NormalizeBarcode(code, true)
+		testutils.ExpectSuccess(errors.Is(err, ErrInvalidBarcode), t)
+	}
+}
+
+func TestBarcodes(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	mate := Item{Id: "00000000-0000-0000-0000-00000000000a", Name: "Club-Mate", Price: 150,
+		Barcodes: []Barcode{{Code: "4029764001807", Multiplier: 1}}}
+	cola := Item{Id: "00000000-0000-0000-0000-00000000000b", Name: "Cola", Price: 120}
+	for _, item := range []Item{mate, cola} {
+		testutils.FailOnError(InsertNewItem(ctx, &item, db), t)
+	}
+
+	// the small bottle and the crate resolve to the same item
+	testutils.FailOnError(AddBarcode(ctx, mate.Id, Barcode{Code: "4029764001883", Multiplier: 1}, db), t)
+	testutils.FailOnError(AddBarcode(ctx, mate.Id, Barcode{Code: "4029764001999", Multiplier: 20}, db), t)
+	item, multiplier, err := GetItemByBarcode(ctx, "4029764001999", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(item.Id, mate.Id, t)
+	testutils.ExpectEqual(multiplier, 20, t)
+	testutils.ExpectEqual(len(item.Barcodes), 3, t)
+	testutils.ExpectEqual(item.Barcodes[2].Multiplier, 20, t)
+
+	// adding a barcode again changes its multiplier, but it can't be taken from another item
+	testutils.FailOnError(AddBarcode(ctx, mate.Id, Barcode{Code: "4029764001999", Multiplier: 24}, db), t)
+	_, multiplier, err = GetItemByBarcode(ctx, "4029764001999", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(multiplier, 24, t)
+	err = AddBarcode(ctx, cola.Id, Barcode{Code: "4029764001999", Multiplier: 1}, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrBarcodeInUse), t)
+
+	// unless the other item has been archived
+	testutils.FailOnError(SetArchived(ctx, mate.Id, true, db), t)
+	testutils.FailOnError(AddBarcode(ctx, cola.Id, Barcode{Code: "4029764001999", Multiplier: 1}, db), t)
+	item, _, err = GetItemByBarcode(ctx, "4029764001999", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(item.Id, cola.Id, t)
+
+	testutils.FailOnError(RemoveBarcode(ctx, cola.Id, "4029764001999", db), t)
+	_, _, err = GetItemByBarcode(ctx, "4029764001999", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+	err = RemoveBarcode(ctx, cola.Id, "4029764001999", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchBarcode), t)
+}
+
+func TestScanBarcode(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	mate := Item{Id: "00000000-0000-0000-0000-00000000000a", Name: "Club-Mate", Price: 150,
+		Barcodes: []Barcode{{Code: "0036000291452", Multiplier: 1}}}
+	testutils.FailOnError(InsertNewItem(ctx, &mate, db), t)
+	// stored before barcodes were validated
+	_, err := db.ExecContext(ctx, `INSERT INTO item_barcodes (barcode, item_id, multiplier) VALUES ($1, $2, $3)`,
+		"12345", mate.Id, 1)
+	testutils.FailOnError(err, t)
+
+	for _, code := range []string{"036000291452", "0036000291452", "12345"} {
+		item, _, err := ScanBarcode(ctx, code, false, db)
+		testutils.FailOnError(err, t)
+		testutils.ExpectEqual(item.Id, mate.Id, t)
+	}
+	_, _, err = ScanBarcode(ctx, "4029764001807", false, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+	_, _, err = ScanBarcode(ctx, "54321", false, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidBarcode), t)
+}
@@ -0,0 +1,106 @@
+package items
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrNoSuchCategory = errors.New("no such category")
+
+// MaxCategoryPosition
+// Positions are kept small, so they can be used in the sort key of items grouped by their category
+const MaxCategoryPosition = 9999
+
+// Category
+// A group of items like "Mate" or "Snacks". Categories are ordered by their position.
+type Category struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+}
+
+func GetCategories(ctx context.Context, db *sql.DB) ([]Category, error) {
+	categories := make([]Category, 0)
+	result, err := db.QueryContext(ctx, `SELECT id, name, position FROM categories ORDER BY position, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var category Category
+		err = result.Scan(&category.Id, &category.Name, &category.Position)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, result.Err()
+}
+
+func getCategoryWhere(ctx context.Context, db *sql.DB, condition string, arg any) (Category, error) {
+	var category Category
+	err := db.QueryRowContext(ctx, `SELECT id, name, position FROM categories WHERE `+condition, arg).
+		Scan(&category.Id, &category.Name, &category.Position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Category{}, ErrNoSuchCategory
+	}
+	return category, err
+}
+
+func GetCategoryById(ctx context.Context, id string, db *sql.DB) (Category, error) {
+	return getCategoryWhere(ctx, db, "id = $1", id)
+}
+
+func GetCategoryByName(ctx context.Context, name string, db *sql.DB) (Category, error) {
+	return getCategoryWhere(ctx, db, "name = $1", name)
+}
+
+func InsertNewCategory(ctx context.Context, category Category, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO categories (id, name, position) VALUES ($1, $2, $3)`,
+		category.Id, category.Name, category.Position)
+	return err
+}
+
+func UpdateCategory(ctx context.Context, category Category, db *sql.DB) error {
+	result, err := db.ExecContext(ctx, `UPDATE categories SET name = $1, position = $2 WHERE id = $3`,
+		category.Name, category.Position, category.Id)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err == nil && updated == 0 {
+		return ErrNoSuchCategory
+	}
+	return err
+}
+
+// DeleteCategory
+// Delete the category, its items are kept without a category
+func DeleteCategory(ctx context.Context, id string, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = deleteCategoryWithTransaction(ctx, id, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func deleteCategoryWithTransaction(ctx context.Context, id string, tx *sql.Tx) error {
+	result, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNoSuchCategory
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE items SET category = NULL WHERE category = $1`, id)
+	return err
+}
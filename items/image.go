@@ -0,0 +1,182 @@
+package items
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"slices"
+)
+
+var (
+	ErrNoImage            = errors.New("the item has no image")
+	ErrInvalidImageSize   = errors.New("invalid image size")
+	ErrImageNotResizeable = errors.New("the image can not be resized")
+	ErrImageTooLarge      = errors.New("image too large (max 4096x4096 pixels allowed)")
+)
+
+// MaxImageDimension
+// The maximum width and height of uploaded images in pixels. Decoding an image takes memory proportional to its
+// pixels, not its file size, so a small file could otherwise exhaust the memory once a thumbnail is generated.
+const MaxImageDimension = 4096
+
+// ThumbnailSizes
+// The sizes thumbnails can be requested in, i.e. the maximum width and height in pixels
+var ThumbnailSizes = []int{128, 512}
+
+type Image struct {
+	ContentType string
+	Data        []byte
+}
+
+// GetImage
+// Get the original image of the item
+func GetImage(ctx context.Context, itemId string, db *sql.DB) (Image, error) {
+	var data []byte
+	err := db.QueryRowContext(ctx, `SELECT image FROM items WHERE id = $1`, itemId).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Image{}, ErrNoSuchItem
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	if len(data) == 0 {
+		return Image{}, ErrNoImage
+	}
+	return Image{ContentType: http.DetectContentType(data), Data: data}, nil
+}
+
+// GetThumbnail
+// Get the image of the item resized to fit into the given size. Thumbnails are generated on the first request and
+// kept in the database. Images which are already small enough, or which can't be decoded, are returned unchanged.
+func GetThumbnail(ctx context.Context, itemId string, size int, db *sql.DB) (Image, error) {
+	if !slices.Contains(ThumbnailSizes, size) {
+		return Image{}, ErrInvalidImageSize
+	}
+	var thumbnail Image
+	err := db.QueryRowContext(ctx, `SELECT content_type, data FROM item_thumbnails WHERE item_id = $1 AND size = $2`,
+		itemId, size).Scan(&thumbnail.ContentType, &thumbnail.Data)
+	if err == nil {
+		return thumbnail, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Image{}, err
+	}
+
+	original, err := GetImage(ctx, itemId, db)
+	if err != nil {
+		return Image{}, err
+	}
+	thumbnail, err = resizeImage(original.Data, size)
+	if errors.Is(err, ErrImageNotResizeable) {
+		return original, nil
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	// concurrent requests may generate the same thumbnail, it does not matter which one is kept
+	_, err = db.ExecContext(ctx, `INSERT INTO item_thumbnails (item_id, size, content_type, data) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`, itemId, size, thumbnail.ContentType, thumbnail.Data)
+	return thumbnail, err
+}
+
+// RemoveImage
+// Remove the image of the item, together with its thumbnails
+func RemoveImage(ctx context.Context, itemId string, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = setImageWithTransaction(ctx, itemId, nil, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func setImageWithTransaction(ctx context.Context, itemId string, data []byte, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `UPDATE items SET image = $1 WHERE id = $2`, data, itemId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM item_thumbnails WHERE item_id = $1`, itemId)
+	return err
+}
+
+// CheckImageSize
+// Check that the image does not exceed MaxImageDimension, reading only its header. Data which is not an image in a
+// known format is accepted, it is never decoded.
+func CheckImageSize(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	if config.Width > MaxImageDimension || config.Height > MaxImageDimension {
+		return ErrImageTooLarge
+	}
+	return nil
+}
+
+// resizeImage
+// Scale the image down to fit into a square of the given size, keeping its aspect ratio. Every pixel of the result
+// is the average of the pixels it covers in the original image. Images with transparency are encoded as png, all
+// others as jpeg. Images exceeding MaxImageDimension, which might have been stored before it was checked, are not
+// decoded at all.
+func resizeImage(data []byte, size int) (Image, error) {
+	if CheckImageSize(data) != nil {
+		return Image{}, ErrImageNotResizeable
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrImageNotResizeable
+	}
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return Image{}, ErrImageNotResizeable
+	}
+	dstWidth, dstHeight := size, size
+	if width > height {
+		dstHeight = max(1, height*size/width)
+	} else {
+		dstWidth = max(1, width*size/height)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := range dstHeight {
+		y0, y1 := y*height/dstHeight, (y+1)*height/dstHeight
+		for x := range dstWidth {
+			x0, x1 := x*width/dstWidth, (x+1)*width/dstWidth
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := range 4 {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			count := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for c := range 4 {
+				dst.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if format == "png" || format == "gif" {
+		err = png.Encode(&buf, dst)
+		return Image{ContentType: "image/png", Data: buf.Bytes()}, err
+	}
+	err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	return Image{ContentType: "image/jpeg", Data: buf.Bytes()}, err
+}
@@ -0,0 +1,106 @@
+package items
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/Port39/go-drink/testutils"
+)
+
+func testPng(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	testutils.FailOnError(png.Encode(&buf, img), t)
+	return buf.Bytes()
+}
+
+func TestResizeImage(t *testing.T) {
+	resized, err := resizeImage(testPng(t, 600, 300), 128)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(resized.ContentType, "image/png", t)
+	decoded, _, err := image.Decode(bytes.NewReader(resized.Data))
+	testutils.FailOnError(err, t)
+	// the aspect ratio is kept
+	testutils.ExpectEqual(decoded.Bounds().Dx(), 128, t)
+	testutils.ExpectEqual(decoded.Bounds().Dy(), 64, t)
+	r, g, _, a := decoded.At(10, 10).RGBA()
+	testutils.ExpectEqual(r, uint32(0xffff), t)
+	testutils.ExpectEqual(g, uint32(0), t)
+	testutils.ExpectEqual(a, uint32(0xffff), t)
+
+	// small images are never scaled up
+	_, err = resizeImage(testPng(t, 100, 50), 128)
+	testutils.ExpectSuccess(errors.Is(err, ErrImageNotResizeable), t)
+	_, err = resizeImage([]byte("<svg></svg>"), 128)
+	testutils.ExpectSuccess(errors.Is(err, ErrImageNotResizeable), t)
+	// huge images are not even decoded
+	_, err = resizeImage(testPng(t, MaxImageDimension+1, 1), 128)
+	testutils.ExpectSuccess(errors.Is(err, ErrImageNotResizeable), t)
+}
+
+func TestCheckImageSize(t *testing.T) {
+	testutils.FailOnError(CheckImageSize(testPng(t, MaxImageDimension, 1)), t)
+	testutils.ExpectSuccess(errors.Is(CheckImageSize(testPng(t, 1, MaxImageDimension+1)), ErrImageTooLarge), t)
+	testutils.FailOnError(CheckImageSize([]byte("<svg></svg>")), t)
+}
+
+func TestGetThumbnail(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	item := Item{Id: "00000000-0000-0000-0000-00000000000a", Name: "Mate", Price: 150, Image: testPng(t, 1024, 1024)}
+	testutils.FailOnError(InsertNewItem(ctx, &item, db), t)
+	testutils.ExpectEqual(item.ImageUrl, "/items/"+item.Id+"/image", t)
+
+	original, err := GetImage(ctx, item.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(original.ContentType, "image/png", t)
+	testutils.ExpectSuccess(bytes.Equal(original.Data, item.Image), t)
+
+	thumbnail, err := GetThumbnail(ctx, item.Id, 128, db)
+	testutils.FailOnError(err, t)
+	cached, err := GetThumbnail(ctx, item.Id, 128, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(bytes.Equal(thumbnail.Data, cached.Data), t)
+	_, err = GetThumbnail(ctx, item.Id, 100, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidImageSize), t)
+
+	// replacing the image discards the thumbnails
+	item.Image = testPng(t, 256, 256)
+	testutils.FailOnError(UpdateItem(ctx, &item, db), t)
+	thumbnail, err = GetThumbnail(ctx, item.Id, 128, db)
+	testutils.FailOnError(err, t)
+	decoded, _, err := image.Decode(bytes.NewReader(thumbnail.Data))
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(decoded.Bounds().Dx(), 128, t)
+
+	// updating other attributes keeps the image
+	item.Image = nil
+	item.Price = 120
+	testutils.FailOnError(UpdateItem(ctx, &item, db), t)
+	retrieved, err := GetItemById(ctx, item.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.ImageUrl, item.ImageUrl, t)
+
+	testutils.FailOnError(RemoveImage(ctx, item.Id, db), t)
+	_, err = GetThumbnail(ctx, item.Id, 128, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoImage), t)
+	retrieved, err = GetItemById(ctx, item.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.ImageUrl, "", t)
+
+	_, err = GetImage(ctx, "00000000-0000-0000-0000-0000000000ff", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+}
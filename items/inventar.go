@@ -3,127 +3,468 @@ package items
 import (
 	"context"
 	"database/sql"
-	"encoding/base64"
 	"errors"
 	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Port39/go-drink/pagination"
+)
+
+var (
+	ErrNoSuchItem        = errors.New("no such item")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrItemSold          = errors.New("the item has been sold before, archive it instead")
 )
 
+// Item
+// An item of the inventory. The image is not loaded with the item, but served separately at the image url.
+// Archived items are no longer sold, but kept for the history. The deposit for the bottle is charged on top of the
+// price, and credited back when the empty bottle is returned.
 type Item struct {
-	Name    string `json:"name"`
-	Price   int    `json:"price"`
-	Image   string `json:"image"`
-	Amount  int    `json:"amount"`
-	Id      string `json:"id"`
-	Barcode string `json:"barcode"`
-}
-
-func VerifyItemsTableExists(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS items (
-    		id VARCHAR (36) PRIMARY KEY,
-    		name VARCHAR (64) UNIQUE NOT NULL,
-    		price INTEGER,
-    		image bytea,
-    		amount INTEGER,
-    		barcode VARCHAR (128)
-		)`)
-	return err
+	Name         string    `json:"name"`
+	Price        int       `json:"price"`
+	Image        []byte    `json:"-"`
+	ImageUrl     string    `json:"imageUrl,omitempty"`
+	Amount       int       `json:"amount"`
+	Id           string    `json:"id"`
+	Barcodes     []Barcode `json:"barcodes"`
+	Archived     bool      `json:"archived"`
+	CategoryId   string    `json:"categoryId,omitempty"`
+	CategoryName string    `json:"categoryName,omitempty"`
+	Tags         []string  `json:"tags"`
+	MinStock     int       `json:"minStock"`
+	Deposit      int       `json:"deposit"`
+	// CostPrice is the weighted average purchase cost, it is only shown in the reports
+	CostPrice int `json:"-"`
+	// categoryPosition is part of the sort key when items are grouped by their category
+	categoryPosition int
+}
+
+// uncategorized items are shown after all categories
+const uncategorizedPosition = MaxCategoryPosition + 1
+
+const selectItems = `SELECT items.id, items.name, COALESCE(items.price, 0),
+	items.image IS NOT NULL AND length(items.image) > 0, COALESCE(items.amount, 0), items.archived, items.category, c.name, c.position, items.min_stock,
+	items.deposit, items.cost_price
+	FROM items LEFT JOIN categories c ON c.id = items.category`
+
+func scanItem(row scanner) (Item, error) {
+	var item Item
+	var hasImage bool
+	var categoryId, categoryName sql.NullString
+	var categoryPosition sql.NullInt64
+	err := row.Scan(&item.Id, &item.Name, &item.Price, &hasImage, &item.Amount, &item.Archived,
+		&categoryId, &categoryName, &categoryPosition, &item.MinStock,
+		&item.Deposit, &item.CostPrice)
+	if hasImage {
+		item.ImageUrl = "/items/" + item.Id + "/image"
+	}
+	item.CategoryId, item.CategoryName = categoryId.String, categoryName.String
+	item.categoryPosition = uncategorizedPosition
+	if categoryPosition.Valid {
+		item.categoryPosition = int(categoryPosition.Int64)
+	}
+	item.Tags = make([]string, 0)
+	item.Barcodes = make([]Barcode, 0)
+	return item, err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// queryer
+// Either a database or a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// itemIdsIn
+// Build the list for an IN condition matching the ids of the items. The indices map the ids to their items.
+func itemIdsIn(items []Item) (string, []any, map[string]int) {
+	indices := make(map[string]int, len(items))
+	placeholders := make([]string, 0, len(items))
+	args := make([]any, 0, len(items))
+	for i, item := range items {
+		indices[item.Id] = i
+		args = append(args, item.Id)
+		placeholders = append(placeholders, "$"+strconv.Itoa(len(args)))
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")", args, indices
+}
+
+// loadDetails
+// Add the tags and barcodes, which are kept in separate tables, to the items
+func loadDetails(ctx context.Context, q queryer, items []Item) error {
+	err := loadTags(ctx, q, items)
+	if err != nil {
+		return err
+	}
+	return loadBarcodes(ctx, q, items)
+}
+
+// loadTags
+// Add the tags to the items
+func loadTags(ctx context.Context, q queryer, items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	in, args, indices := itemIdsIn(items)
+	result, err := q.QueryContext(ctx, `SELECT item_id, tag FROM item_tags WHERE item_id IN `+in+` ORDER BY tag`, args...)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	for result.Next() {
+		var itemId, tag string
+		err = result.Scan(&itemId, &tag)
+		if err != nil {
+			return err
+		}
+		items[indices[itemId]].Tags = append(items[indices[itemId]].Tags, tag)
+	}
+	return result.Err()
 }
 
 func GetAllItems(ctx context.Context, db *sql.DB) ([]Item, error) {
 	items := make([]Item, 0)
 
-	result, err := db.QueryContext(ctx, `SELECT id, name, price, image, amount, barcode FROM items`)
+	result, err := db.QueryContext(ctx, selectItems)
 	if err != nil {
 		return nil, err
 	}
 	defer result.Close()
 	for result.Next() {
-		var item Item
-		var imageData []byte
-		err = result.Scan(&item.Id, &item.Name, &item.Price, &imageData, &item.Amount, &item.Barcode)
-		item.Image = base64.StdEncoding.EncodeToString(imageData)
+		item, err := scanItem(result)
 		if err != nil {
 			log.Println("Error reading results:", err)
 		}
 		items = append(items, item)
 	}
-	return items, nil
+	return items, loadDetails(ctx, db, items)
 }
 
-func GetItemByName(ctx context.Context, name string, db *sql.DB) (Item, error) {
-	result, err := db.QueryContext(ctx, "SELECT id, name, price, image, amount, barcode FROM items WHERE name = $1", name)
+// Sorts
+// The keys items can be sorted by, and their columns. Sorting by category orders the categories by their position,
+// and the items of each category by their name.
+var Sorts = map[string]string{
+	"category": "(10000 + COALESCE(c.position, " + strconv.Itoa(uncategorizedPosition) + ")) || items.name",
+	"name":     "items.name",
+	"price":    "COALESCE(items.price, 0)",
+	"amount":   "COALESCE(items.amount, 0)",
+}
+
+// Filter
+// Restricts the listed items. Empty fields match all items.
+type Filter struct {
+	IncludeArchived bool
+	CategoryId      string
+	Tag             string
+}
+
+// GetItems
+// Get a page of the items matching the filter. If there are more items, a cursor pointing to the next page is returned
+// as well.
+func GetItems(ctx context.Context, filter Filter, page pagination.Page, db *sql.DB) ([]Item, *pagination.Cursor, error) {
+	items := make([]Item, 0)
+	var query pagination.Query
+	if !filter.IncludeArchived {
+		query.Where("NOT items.archived")
+	}
+	if filter.CategoryId != "" {
+		query.Where("items.category = " + query.Arg(filter.CategoryId))
+	}
+	if filter.Tag != "" {
+		query.Where("items.id IN (SELECT item_id FROM item_tags WHERE tag = " + query.Arg(filter.Tag) + ")")
+	}
+	statement, args := query.Build(selectItems, "items.id", page)
+	result, err := db.QueryContext(ctx, statement, args...)
 	if err != nil {
-		return Item{}, err
+		return nil, nil, err
 	}
 	defer result.Close()
-	if !result.Next() {
-		return Item{}, errors.New("no such item")
+	for result.Next() {
+		item, err := scanItem(result)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
 	}
-	var item Item
-	var imageData []byte
-	err = result.Scan(&item.Id, &item.Name, &item.Price, &imageData, &item.Amount, &item.Barcode)
-	item.Image = base64.StdEncoding.EncodeToString(imageData)
-	return item, err
+	if err = result.Err(); err != nil {
+		return nil, nil, err
+	}
+	items, next := pagination.Trim(items, page, func(item Item) (any, string) {
+		switch page.Sort {
+		case "category":
+			return strconv.Itoa(10000+item.categoryPosition) + item.Name, item.Id
+		case "price":
+			return item.Price, item.Id
+		case "amount":
+			return item.Amount, item.Id
+		}
+		return item.Name, item.Id
+	})
+	return items, next, loadDetails(ctx, db, items)
 }
 
-func GetItemById(ctx context.Context, id string, db *sql.DB) (Item, error) {
-	result, err := db.QueryContext(ctx, "SELECT id, name, price, image, amount, barcode FROM items WHERE id = $1", id)
+// IsLowOnStock
+// Whether the amount of the item is below its minimum stock
+func (item *Item) IsLowOnStock() bool {
+	return item.Amount < item.MinStock
+}
+
+// MissingStock
+// How many items are missing to reach the minimum stock
+func (item *Item) MissingStock() int {
+	return max(0, item.MinStock-item.Amount)
+}
+
+// GetLowStockItems
+// Get all items which are not archived and low on stock, ordered by their category like the item list
+func GetLowStockItems(ctx context.Context, db *sql.DB) ([]Item, error) {
+	items := make([]Item, 0)
+	result, err := db.QueryContext(ctx, selectItems+" WHERE NOT items.archived AND items.amount < items.min_stock ORDER BY "+
+		Sorts["category"])
 	if err != nil {
-		return Item{}, err
+		return nil, err
 	}
 	defer result.Close()
-	if !result.Next() {
-		return Item{}, errors.New("no such item")
+	for result.Next() {
+		item, err := scanItem(result)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
 	}
-	var item Item
-	var imageData []byte
-	err = result.Scan(&item.Id, &item.Name, &item.Price, &imageData, &item.Amount, &item.Barcode)
-	item.Image = base64.StdEncoding.EncodeToString(imageData)
-	return item, err
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+	return items, loadDetails(ctx, db, items)
 }
 
-func GetItemByBarcode(ctx context.Context, barcode string, db *sql.DB) (Item, error) {
-	result, err := db.QueryContext(ctx, "SELECT id, name, price, image, amount, barcode FROM items WHERE barcode = $1", barcode)
+func getItemWhere(ctx context.Context, q queryer, condition string, arg any) (Item, error) {
+	item, err := scanItem(q.QueryRowContext(ctx, selectItems+" WHERE "+condition, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, ErrNoSuchItem
+	}
 	if err != nil {
 		return Item{}, err
 	}
-	defer result.Close()
-	if !result.Next() {
-		return Item{}, errors.New("no such item")
-	}
-	var item Item
-	var imageData []byte
-	err = result.Scan(&item.Id, &item.Name, &item.Price, &imageData, &item.Amount, &item.Barcode)
-	item.Image = base64.StdEncoding.EncodeToString(imageData)
-	return item, err
+	found := []Item{item}
+	err = loadDetails(ctx, q, found)
+	return found[0], err
+}
+
+func GetItemByName(ctx context.Context, name string, db *sql.DB) (Item, error) {
+	return getItemWhere(ctx, db, "items.name = $1", name)
+}
+
+func GetItemById(ctx context.Context, id string, db *sql.DB) (Item, error) {
+	return getItemWhere(ctx, db, "items.id = $1", id)
+}
+
+func GetItemByIdWithTransaction(ctx context.Context, id string, tx *sql.Tx) (Item, error) {
+	return getItemWhere(ctx, tx, "items.id = $1", id)
 }
 
 func InsertNewItem(ctx context.Context, item *Item, db *sql.DB) error {
-	imageData, err := base64.StdEncoding.DecodeString(item.Image)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = db.ExecContext(ctx, "INSERT INTO items (id, name, price, image, amount, barcode) VALUES ($1, $2, $3, $4, $5, $6)",
-		item.Id, item.Name, item.Price, imageData, item.Amount, item.Barcode)
+	err = insertNewItemWithTransaction(ctx, item, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	err = tx.Commit()
+	if err == nil && len(item.Image) > 0 {
+		item.ImageUrl = "/items/" + item.Id + "/image"
+	}
 	return err
 }
 
+func insertNewItemWithTransaction(ctx context.Context, item *Item, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO items (id, name, price, image, amount, category, min_stock, deposit)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		item.Id, item.Name, item.Price, item.Image, item.Amount, nullIfEmpty(item.CategoryId), item.MinStock, item.Deposit)
+	if err != nil {
+		return err
+	}
+	for _, barcode := range item.Barcodes {
+		err = addBarcodeWithTransaction(ctx, item.Id, barcode, tx)
+		if err != nil {
+			return err
+		}
+	}
+	return setTagsWithTransaction(ctx, item.Id, item.Tags, tx)
+}
+
+// setTagsWithTransaction
+// Replace the tags of the item
+func setTagsWithTransaction(ctx context.Context, itemId string, tags []string, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM item_tags WHERE item_id = $1", itemId)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, "INSERT INTO item_tags (item_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", itemId, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// UpdateItem
+// Update all attributes of the item, including its category and tags. The image is only replaced if the item has one,
+// use RemoveImage to remove it. Barcodes are only added, use RemoveBarcode to remove them.
+func UpdateItem(ctx context.Context, item *Item, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = UpdateItemWithTransaction(ctx, item, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
 func UpdateItemWithTransaction(ctx context.Context, item *Item, tx *sql.Tx) error {
-	imageData, err := base64.StdEncoding.DecodeString(item.Image)
+	_, err := tx.ExecContext(ctx, `UPDATE items SET name = $1, price = $2, amount = $3, category = $4, min_stock = $5,
+		deposit = $6 WHERE id = $7`, item.Name, item.Price, item.Amount, nullIfEmpty(item.CategoryId), item.MinStock,
+		item.Deposit, item.Id)
 	if err != nil {
 		return err
 	}
-	_, err = tx.ExecContext(ctx, "UPDATE items SET name = $1, price = $2, image = $3, amount = $4, barcode = $5 WHERE id = $6",
-		item.Name, item.Price, imageData, item.Amount, item.Barcode, item.Id)
+	for _, barcode := range item.Barcodes {
+		err = addBarcodeWithTransaction(ctx, item.Id, barcode, tx)
+		if err != nil {
+			return err
+		}
+	}
+	err = setTagsWithTransaction(ctx, item.Id, item.Tags, tx)
+	if err != nil || len(item.Image) == 0 {
+		return err
+	}
+	return setImageWithTransaction(ctx, item.Id, item.Image, tx)
+}
+
+// SetCostPriceWithTransaction
+// Set the weighted average purchase cost of the item
+func SetCostPriceWithTransaction(ctx context.Context, id string, costPrice int, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "UPDATE items SET cost_price = $1 WHERE id = $2", costPrice, id)
 	return err
 }
 
-func UpdateItem(ctx context.Context, item *Item, db *sql.DB) error {
-	imageData, err := base64.StdEncoding.DecodeString(item.Image)
+// SetArchived
+// Archive an item, or bring an archived item back into the assortment
+func SetArchived(ctx context.Context, id string, archived bool, db *sql.DB) error {
+	result, err := db.ExecContext(ctx, "UPDATE items SET archived = $1 WHERE id = $2", archived, id)
 	if err != nil {
 		return err
 	}
-	_, err = db.ExecContext(ctx, "UPDATE items SET name = $1, price = $2, image = $3, amount = $4, barcode = $5 WHERE id = $6",
-		item.Name, item.Price, imageData, item.Amount, item.Barcode, item.Id)
+	updated, err := result.RowsAffected()
+	if err == nil && updated == 0 {
+		return ErrNoSuchItem
+	}
 	return err
 }
+
+// DeleteItem
+// Delete an item together with its thumbnails. Items which are referenced by transactions can't be deleted, they
+// have to be archived instead.
+func DeleteItem(ctx context.Context, id string, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = deleteItemWithTransaction(ctx, id, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func deleteItemWithTransaction(ctx context.Context, id string, tx *sql.Tx) error {
+	// the item is deleted before looking for transactions, so concurrent purchases either finished before and are
+	// found, or wait for the deleted row and fail
+	result, err := tx.ExecContext(ctx, "DELETE FROM items WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNoSuchItem
+	}
+	var sold bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM transactions WHERE itemId = $1)", id).Scan(&sold)
+	if err != nil {
+		return err
+	}
+	if sold {
+		return ErrItemSold
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM item_thumbnails WHERE item_id = $1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM item_tags WHERE item_id = $1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM item_barcodes WHERE item_id = $1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM stock_movements WHERE item_id = $1", id)
+	return err
+}
+
+// SetStockWithTransaction
+// Set the stock of the item to amount, and return the amount it had before. The first update locks the item, so
+// concurrent changes can't happen between reading the previous amount and setting the new one.
+func SetStockWithTransaction(ctx context.Context, itemId string, amount int, tx *sql.Tx) (int, error) {
+	var previous int
+	err := tx.QueryRowContext(ctx, `UPDATE items SET amount = COALESCE(amount, 0) WHERE id = $1 RETURNING amount`,
+		itemId).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNoSuchItem
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE items SET amount = $1 WHERE id = $2`, amount, itemId)
+	return previous, err
+}
+
+// ChangeStockWithTransaction
+// Atomically add diff (which may be negative) to the stock of the item, and return the resulting amount.
+// The check for a sufficient stock is evaluated by the database, so concurrent changes can't lose updates or result
+// in a negative stock.
+func ChangeStockWithTransaction(ctx context.Context, itemId string, diff int, tx *sql.Tx) (int, error) {
+	result, err := tx.QueryContext(ctx, `UPDATE items SET amount = amount + $1 WHERE id = $2 AND amount + $1 >= 0 RETURNING amount`,
+		diff, itemId)
+	if err != nil {
+		return 0, err
+	}
+	defer result.Close()
+	if !result.Next() {
+		if err = result.Err(); err != nil {
+			return 0, err
+		}
+		return 0, ErrInsufficientStock
+	}
+	var amount int
+	err = result.Scan(&amount)
+	return amount, err
+}
@@ -0,0 +1,143 @@
+package items
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/Port39/go-drink/pagination"
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestArchiveAndDeleteItem(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	mate := Item{Id: "00000000-0000-0000-0000-00000000000a", Name: "Mate", Price: 150, Amount: 10,
+		Barcodes: []Barcode{{Code: "4029764001807", Multiplier: 1}}}
+	cola := Item{Id: "00000000-0000-0000-0000-00000000000b", Name: "Cola", Price: 120, Amount: 5}
+	for _, item := range []Item{mate, cola} {
+		testutils.FailOnError(InsertNewItem(ctx, &item, db), t)
+	}
+	page, err := pagination.FromQuery(url.Values{}, Sorts, "name")
+	testutils.FailOnError(err, t)
+
+	testutils.FailOnError(SetArchived(ctx, mate.Id, true, db), t)
+	listed, _, err := GetItems(ctx, Filter{}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(listed), 1, t)
+	testutils.ExpectEqual(listed[0].Id, cola.Id, t)
+	listed, _, err = GetItems(ctx, Filter{IncludeArchived: true}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(listed), 2, t)
+
+	// archived items can still be resolved by their id, but not by their barcode
+	retrieved, err := GetItemById(ctx, mate.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(retrieved.Archived, t)
+	_, _, err = GetItemByBarcode(ctx, mate.Barcodes[0].Code, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+
+	testutils.FailOnError(SetArchived(ctx, mate.Id, false, db), t)
+	retrieved, _, err = GetItemByBarcode(ctx, mate.Barcodes[0].Code, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectFailure(retrieved.Archived, t)
+
+	testutils.FailOnError(DeleteItem(ctx, cola.Id, db), t)
+	_, err = GetItemById(ctx, cola.Id, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+	err = DeleteItem(ctx, cola.Id, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+	err = SetArchived(ctx, cola.Id, true, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+}
+
+func TestGetItems_GroupedByCategory(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	mate := Category{Id: "00000000-0000-0000-0000-0000000000c1", Name: "Mate", Position: 1}
+	snacks := Category{Id: "00000000-0000-0000-0000-0000000000c2", Name: "Snacks", Position: 2}
+	for _, category := range []Category{snacks, mate} {
+		testutils.FailOnError(InsertNewCategory(ctx, category, db), t)
+	}
+	for _, item := range []Item{
+		{Id: "00000000-0000-0000-0000-00000000000a", Name: "Chips", CategoryId: snacks.Id, Tags: []string{"vegan"}},
+		{Id: "00000000-0000-0000-0000-00000000000b", Name: "Water"},
+		{Id: "00000000-0000-0000-0000-00000000000c", Name: "Tschunk", CategoryId: mate.Id},
+		{Id: "00000000-0000-0000-0000-00000000000d", Name: "Club-Mate", CategoryId: mate.Id, Tags: []string{"vegan", "caffeine"}},
+	} {
+		testutils.FailOnError(InsertNewItem(ctx, &item, db), t)
+	}
+
+	// categories are ordered by their position and their items by name, pages continue across categories
+	query := url.Values{"limit": {"3"}}
+	page, err := pagination.FromQuery(query, Sorts, "category")
+	testutils.FailOnError(err, t)
+	listed, next, err := GetItems(ctx, Filter{}, page, db)
+	testutils.FailOnError(err, t)
+	query.Set("cursor", next.String())
+	page, err = pagination.FromQuery(query, Sorts, "category")
+	testutils.FailOnError(err, t)
+	rest, _, err := GetItems(ctx, Filter{}, page, db)
+	testutils.FailOnError(err, t)
+	listed = append(listed, rest...)
+	testutils.ExpectEqual(len(listed), 4, t)
+	for i, name := range []string{"Club-Mate", "Tschunk", "Chips", "Water"} {
+		testutils.ExpectEqual(listed[i].Name, name, t)
+	}
+	testutils.ExpectEqual(listed[0].CategoryName, "Mate", t)
+	testutils.ExpectEqual(len(listed[0].Tags), 2, t)
+	testutils.ExpectEqual(listed[0].Tags[0], "caffeine", t)
+	testutils.ExpectEqual(listed[3].CategoryId, "", t)
+
+	page, err = pagination.FromQuery(url.Values{}, Sorts, "name")
+	testutils.FailOnError(err, t)
+	listed, _, err = GetItems(ctx, Filter{CategoryId: mate.Id}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(listed), 2, t)
+	listed, _, err = GetItems(ctx, Filter{Tag: "vegan"}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(listed), 2, t)
+	testutils.ExpectEqual(listed[0].Name, "Chips", t)
+
+	// moving the category changes the order, deleting it keeps its items
+	mate.Position = 3
+	testutils.FailOnError(UpdateCategory(ctx, mate, db), t)
+	categories, err := GetCategories(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(categories[0].Id, snacks.Id, t)
+	testutils.FailOnError(DeleteCategory(ctx, mate.Id, db), t)
+	item, err := GetItemByName(ctx, "Tschunk", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(item.CategoryId, "", t)
+	err = DeleteCategory(ctx, mate.Id, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchCategory), t)
+}
+
+func TestSetStock(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	mate := Item{Id: "00000000-0000-0000-0000-00000000000a", Name: "Mate", Price: 150, Amount: 10}
+	testutils.FailOnError(InsertNewItem(ctx, &mate, db), t)
+
+	tx, err := db.BeginTx(ctx, nil)
+	testutils.FailOnError(err, t)
+	previous, err := SetStockWithTransaction(ctx, mate.Id, 7, tx)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(previous, 10, t)
+	_, err = SetStockWithTransaction(ctx, "00000000-0000-0000-0000-0000000000ff", 7, tx)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+	testutils.FailOnError(tx.Commit(), t)
+
+	retrieved, err := GetItemById(ctx, mate.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.Amount, 7, t)
+}
@@ -9,6 +9,12 @@ import (
 //go:embed templates/passwordReset.txt
 var passwordResetTemplate string
 
+//go:embed templates/lowStock.txt
+var lowStockTemplate string
+
+//go:embed templates/emailVerification.txt
+var emailVerificationTemplate string
+
 type passwordResetTemplateData struct {
 	Username string
 	Token    string
@@ -24,3 +30,37 @@ func applyPasswordResetTemplate(data passwordResetTemplateData) (string, error)
 
 	return writer.String(), nil
 }
+
+type lowStockTemplateData struct {
+	ItemName string
+	Amount   int
+	MinStock int
+}
+
+func applyLowStockTemplate(data lowStockTemplateData) (string, error) {
+	templ, _ := template.New("lowStock").Parse(lowStockTemplate)
+	writer := new(bytes.Buffer)
+	err := templ.Execute(writer, data)
+	if err != nil {
+		return "", err
+	}
+
+	return writer.String(), nil
+}
+
+type emailVerificationTemplateData struct {
+	Username string
+	Email    string
+	Token    string
+}
+
+func applyEmailVerificationTemplate(data emailVerificationTemplateData) (string, error) {
+	templ, _ := template.New("emailVerification").Parse(emailVerificationTemplate)
+	writer := new(bytes.Buffer)
+	err := templ.Execute(writer, data)
+	if err != nil {
+		return "", err
+	}
+
+	return writer.String(), nil
+}
@@ -36,11 +36,62 @@ func SendPasswordResetTokenMail(username, email, token string) error {
 		return err
 	}
 	message.SetBodyString(mail.TypeTextPlain, msg)
+	return send(message)
+}
+
+// SendEmailVerificationMail
+// Send the token confirming that the address belongs to the user
+func SendEmailVerificationMail(username, email, token string) error {
+	data := emailVerificationTemplateData{
+		Username: username,
+		Email:    email,
+		Token:    token,
+	}
+	message := mail.NewMsg()
+	if err := message.From(from); err != nil {
+		return err
+	}
+	if err := message.To(email); err != nil {
+		return err
+	}
+	message.Subject("Email verification token")
+	msg, err := applyEmailVerificationTemplate(data)
+	if err != nil {
+		return err
+	}
+	message.SetBodyString(mail.TypeTextPlain, msg)
+	return send(message)
+}
+
+// SendLowStockMail
+// Ask the restockers to buy more of an item which is running low
+func SendLowStockMail(recipients []string, itemName string, amount, minStock int) error {
+	data := lowStockTemplateData{
+		ItemName: itemName,
+		Amount:   amount,
+		MinStock: minStock,
+	}
+	message := mail.NewMsg()
+	if err := message.From(from); err != nil {
+		return err
+	}
+	if err := message.To(recipients...); err != nil {
+		return err
+	}
+	message.Subject(itemName + " is running low")
+	msg, err := applyLowStockTemplate(data)
+	if err != nil {
+		return err
+	}
+	message.SetBodyString(mail.TypeTextPlain, msg)
+	return send(message)
+}
+
+func send(message *mail.Msg) error {
 	client, err := mail.NewClient(server, mail.WithPort(port), mail.WithSSLPort(true), mail.WithSMTPAuth(mail.SMTPAuthLogin),
 		mail.WithUsername(user), mail.WithPassword(pass))
 	if err != nil {
 		return err
 	}
-	err = client.DialAndSend(message)
-	return err
+	return client.DialAndSend(message)
 }
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/Port39/go-drink/events"
 	"github.com/Port39/go-drink/handlehttp"
-	"github.com/Port39/go-drink/items"
 	"github.com/Port39/go-drink/mailing"
+	"github.com/Port39/go-drink/migrations"
 	"github.com/Port39/go-drink/session"
 	"github.com/Port39/go-drink/transactions"
 	"github.com/Port39/go-drink/users"
@@ -21,41 +23,39 @@ import (
 var database *sql.DB
 var sessionStore session.Store
 
-func initialize() {
-	config = mkconf()
+func openDatabase() {
 	db, err := sql.Open(config.DbDriver, config.DbConnectionString)
 	if err != nil {
 		log.Fatal("Error connecting to database: ", err)
 	}
 	database = db
-	err = items.VerifyItemsTableExists(database)
-	if err != nil {
-		log.Fatal("Error creating items table: ", err)
-	}
-	err = users.VerifyUsersTableExists(database)
-	if err != nil {
-		log.Fatal("Error creating users table: ", err)
+}
+
+func initialize() {
+	config = mkconf()
+	openDatabase()
+	if config.AutoMigrate {
+		err := migrations.Up(context.Background(), database, config.DbDriver)
+		if err != nil {
+			log.Fatal("Error migrating database: ", err)
+		}
+	} else {
+		pending, err := migrations.HasPending(context.Background(), database, config.DbDriver)
+		if err != nil {
+			log.Fatal("Error checking database migrations: ", err)
+		}
+		if pending {
+			log.Fatal("The database schema is outdated, run \"go-drink migrate up\" first!")
+		}
 	}
-	err = users.VerifyCashUserExists(database)
+	err := users.VerifyCashUserExists(database)
 	if err != nil {
 		log.Fatal("Error creating cash payments user:", err)
 	}
-	err = users.VerifyAuthTableExists(database)
-	if err != nil {
-		log.Fatal("Error creating auth table: ", err)
-	}
 	err = users.VerifyAdminUserExists(database)
 	if err != nil {
 		log.Fatal("Error creating admin user: ", err)
 	}
-	err = users.VerifyPasswordResetTableExists(database)
-	if err != nil {
-		log.Fatal("Error creating password reset token table: ", err)
-	}
-	err = transactions.VerifyTransactionTableExists(database)
-	if err != nil {
-		log.Fatal("Error creating transaction table: ", err)
-	}
 	databaseCleanupTicker := time.NewTicker(4 * time.Hour)
 	go func() {
 		for {
@@ -65,13 +65,33 @@ func initialize() {
 				if err := users.CleanExpiredResetTokens(context.Background(), database); err != nil {
 					log.Println("Error while deleting expired password reset tokens:", err)
 				}
+				if err := users.CleanExpiredVerificationTokens(context.Background(), database); err != nil {
+					log.Println("Error while deleting expired email verification tokens:", err)
+				}
+				mismatches, err := transactions.CheckLedger(context.Background(), database)
+				if err != nil {
+					log.Println("Error while checking the credit ledger:", err)
+				}
+				for _, m := range mismatches {
+					log.Printf("Credit of user %s (%d) does not match the ledger (%d)!\n", m.Username, m.Credit, m.LedgerBalance)
+				}
 			}
 		}
 	}()
 
 	mailing.Configure(config.MailLogin, config.MailPassword, config.MailHost, config.MailPort, config.MailFrom)
+	if len(config.RestockMails) > 0 {
+		events.Subscribe(notifyRestockers)
+	}
+	if config.EventWebhook != "" {
+		events.Subscribe(events.Webhook(config.EventWebhook))
+	}
 
-	sessionStore = session.NewMemoryStore()
+	if config.SessionStore == DatabaseSessionStore {
+		sessionStore = session.NewSqlStore(database)
+	} else {
+		sessionStore = session.NewMemoryStore()
+	}
 	sessionCleanupTicker := time.NewTicker(time.Duration(config.SessionLifetime) * time.Second)
 	go func() {
 		for {
@@ -84,11 +104,29 @@ func initialize() {
 	}()
 }
 
+// notifyRestockers
+// Mail the restock list when an item runs low on stock
+func notifyRestockers(event events.Event) {
+	lowStock, ok := event.Data.(events.LowStock)
+	if !ok {
+		return
+	}
+	err := mailing.SendLowStockMail(config.RestockMails, lowStock.ItemName, lowStock.Amount, lowStock.MinStock)
+	if err != nil {
+		log.Println("Error while sending the low stock notification:", err)
+	}
+}
+
 var noData handlehttp.RequestHandler = func(r *http.Request) (context.Context, any) {
 	return handlehttp.ContextWithStatus(r.Context(), http.StatusOK), nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+
 	initialize()
 
 	http.Handle("GET /static/", http.StripPrefix("/static/", http.FileServerFS(staticFiles)))
@@ -99,20 +137,54 @@ func main() {
 
 	handleEnhanced("GET /items", getItems, toJsonOrHtmlByAccept("templates/items.gohtml"))
 
+	handleEnhanced("GET /items/low-stock", requirePermission(users.PermissionStockRead, getLowStockItems), toJsonOrHtmlByAccept("templates/low-stock.gohtml"))
 	handleEnhanced("GET /items/{id}", getItem, handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
-	handleEnhanced("POST /items/add", verifyRole("admin", addItem), toJsonOrHtmlByAccept("templates/new-item.gohtml"))
-	handleEnhanced("POST /items/update", verifyRole("admin", updateItem), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	// "GET /items/{id}/image" would conflict with "GET /items/barcode/{id}", getItemImage checks the resource instead
+	handleEnhanced("GET /items/{id}/{resource}", getItemImage, handlehttp.AlwaysMapWith(handlehttp.FileMapper))
+	handleEnhanced("POST /items/add", requirePermission(users.PermissionItemsWrite, addItem), toJsonOrHtmlByAccept("templates/new-item.gohtml"))
+	handleEnhanced("POST /items/update", requirePermission(users.PermissionItemsWrite, updateItem), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
 	handleEnhanced("GET /items/barcode/{id}", getItemByBarcode, handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
-
-	handleEnhanced("GET /users", verifyRole("admin", getUsers), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /items/{id}/archive", requirePermission(users.PermissionItemsWrite, setItemArchived(true)), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /items/{id}/unarchive", requirePermission(users.PermissionItemsWrite, setItemArchived(false)), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("DELETE /items/{id}", requirePermission(users.PermissionItemsWrite, deleteItem), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /items/{id}/barcodes", requirePermission(users.PermissionItemsWrite, addItemBarcode), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("DELETE /items/{id}/barcodes/{barcode}", requirePermission(users.PermissionItemsWrite, removeItemBarcode), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /items/{id}/restock", requirePermission(users.PermissionStockRestock, restockItem), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /items/restock", requirePermission(users.PermissionStockRestock, restockDelivery), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /stock/movements", requirePermission(users.PermissionStockRead, getStockMovements), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /stock/stocktake", requirePermission(users.PermissionStockTake, takeStock), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /stock/shrinkage", requirePermission(users.PermissionReportsRead, getShrinkage), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+
+	handleEnhanced("GET /categories", getCategories, handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /categories/add", requirePermission(users.PermissionItemsWrite, addCategory), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /categories/update", requirePermission(users.PermissionItemsWrite, updateCategory), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("DELETE /categories/{id}", requirePermission(users.PermissionItemsWrite, deleteCategory), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+
+	handleEnhanced("GET /pricing-rules", requirePermission(users.PermissionPricingWrite, getPricingRules), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /pricing-rules/add", requirePermission(users.PermissionPricingWrite, addPricingRule), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /pricing-rules/update", requirePermission(users.PermissionPricingWrite, updatePricingRule), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("DELETE /pricing-rules/{id}", requirePermission(users.PermissionPricingWrite, deletePricingRule), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+
+	handleEnhanced("GET /roles", requirePermission(users.PermissionRolesWrite, getRoles), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /permissions", requirePermission(users.PermissionRolesWrite, getPermissions), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /roles/add", requirePermission(users.PermissionRolesWrite, addRole), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /roles/update", requirePermission(users.PermissionRolesWrite, updateRole), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("DELETE /roles/{name}", requirePermission(users.PermissionRolesWrite, deleteRole), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+
+	handleEnhanced("GET /users", requirePermission(users.PermissionUsersRead, getUsers), toJsonOrHtmlByAccept("templates/users.gohtml"))
 	handleEnhanced("GET /users/noauth", getUsersWithNoneAuth, handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
-	handleEnhanced("GET /users/{id}", verifyRole("admin", getUser), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /users/{id}", requirePermission(users.PermissionUsersRead, getUser), toJsonOrHtmlByAccept("templates/user.gohtml"))
+	handleEnhanced("POST /users/update", requirePermission(users.PermissionUsersWrite, updateUser), toJsonOrHtmlByAccept("templates/user.gohtml"))
+	handleEnhanced("POST /users/{id}/deactivate", requirePermission(users.PermissionUsersWrite, setUserDeactivated(true)), toJsonOrHtmlByAccept("templates/user.gohtml"))
+	handleEnhanced("POST /users/{id}/reactivate", requirePermission(users.PermissionUsersWrite, setUserDeactivated(false)), toJsonOrHtmlByAccept("templates/user.gohtml"))
+	handleEnhanced("POST /users/{id}/credit", requirePermission(users.PermissionCreditAdjust, adjustCredit), toJsonOrHtmlByAccept("templates/credit-adjusted.gohtml"))
 
 	handleEnhanced("POST /register/password", registerWithPassword, writeSessionCookie(toJsonOrHtmlByAccept("templates/index.gohtml")))
 
-	handleEnhanced("POST /auth/add", verifyRole("user", addAuthMethod), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /auth/add", requirePermission(users.PermissionAccountOwn, addAuthMethod), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
 	handleEnhanced("POST /auth/password-reset/request", requestPasswordReset, handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
 	handleEnhanced("POST /auth/password-reset", resetPassword, handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /auth/verify-email", verifyEmail, toJsonOrHtmlByAccept("templates/email-verified.gohtml"))
 
 	handleEnhanced("POST /login/password", loginWithPassword, writeSessionCookie(toJsonOrHtmlByAccept("templates/index.gohtml")))
 	handleEnhanced("POST /login/cash", loginCash, handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
@@ -121,11 +193,27 @@ func main() {
 
 	handleEnhanced("POST /logout", logout, writeSessionCookie(toJsonOrHtmlByAccept("templates/index.gohtml")))
 
-	handleEnhanced("POST /buy", verifyRole("user", buyItem), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /buy", requirePermission(users.PermissionItemsBuy, buyItem), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+
+	handleEnhanced("POST /empties/return", requirePermission(users.PermissionItemsBuy, returnEmpties), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /empties/liability", requirePermission(users.PermissionReportsRead, getDepositLiability), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+
+	handleEnhanced("GET /reports/margins", requirePermission(users.PermissionReportsRead, getMarginReport), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /reports/inventory", requirePermission(users.PermissionReportsRead, getInventoryValue), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /reports/revenue", requirePermission(users.PermissionReportsRead, getRevenueReport), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
 
-	handleEnhanced("GET /transactions", verifyRole("admin", getTransactions), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /transactions", requirePermission(users.PermissionTransactionsRead, getTransactions), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /me", requirePermission(users.PermissionAccountOwn, getProfile), toJsonOrHtmlByAccept("templates/profile.gohtml"))
+	handleEnhanced("POST /me/password", requirePermission(users.PermissionAccountOwn, changePassword), writeSessionCookie(toJsonOrHtmlByAccept("templates/password-changed.gohtml")))
+	handleEnhanced("POST /me/email", requirePermission(users.PermissionAccountOwn, changeEmail), toJsonOrHtmlByAccept("templates/profile.gohtml"))
+	handleEnhanced("POST /me/username", requirePermission(users.PermissionAccountOwn, changeUsername), toJsonOrHtmlByAccept("templates/profile.gohtml"))
+	handleEnhanced("GET /me/transactions", requirePermission(users.PermissionAccountOwn, getMyTransactions), toJsonOrHtmlByAccept("templates/my-transactions.gohtml"))
+	handleEnhanced("POST /transactions/{id}/undo", requirePermission(users.PermissionItemsBuy, undoTransaction), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /transactions/{id}/refund", requirePermission(users.PermissionTransactionsRefund, refundTransaction), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
 
-	handleEnhanced("POST /credit", verifyRole("user", changeCredit), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /credit", requirePermission(users.PermissionAccountOwn, changeCredit), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("POST /credit/transfer", requirePermission(users.PermissionAccountOwn, transferCredit), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
+	handleEnhanced("GET /credit/ledger/check", requirePermission(users.PermissionTransactionsRead, checkLedger), handlehttp.AlwaysMapWith(handlehttp.JsonMapper))
 
 	uri := fmt.Sprintf("0.0.0.0:%d", config.Port)
 	log.Println("Serving go-drink on " + uri)
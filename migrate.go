@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Port39/go-drink/migrations"
+)
+
+const migrateUsage = `Usage: go-drink migrate <command>
+
+Commands:
+  up            apply all pending migrations
+  down [steps]  revert the given number of applied migrations (default: 1)
+  status        list all migrations and whether they have been applied`
+
+func runMigrateCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+	config = mkconf()
+	openDatabase()
+	defer database.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		err := migrations.Up(ctx, database, config.DbDriver)
+		if err != nil {
+			log.Fatal("Error migrating database: ", err)
+		}
+		log.Println("Database is up to date.")
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal("The number of steps must be a positive integer!")
+			}
+		}
+		err := migrations.Down(ctx, database, config.DbDriver, steps)
+		if err != nil {
+			log.Fatal("Error reverting migrations: ", err)
+		}
+		log.Println("Migrations reverted.")
+	case "status":
+		status, err := migrations.GetStatus(ctx, database, config.DbDriver)
+		if err != nil {
+			log.Fatal("Error retrieving migration status: ", err)
+		}
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = "applied at " + time.Unix(s.AppliedAt, 0).Format(time.DateTime)
+			}
+			fmt.Printf("%04d %-40s %s\n", s.Version, s.Name, appliedAt)
+		}
+	default:
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+}
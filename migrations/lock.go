@@ -0,0 +1,103 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// postgresLockId is an arbitrary, but fixed key for the postgres advisory lock guarding the migrations
+const postgresLockId = 3903903903
+
+// staleLockAge is the age after which a lock in the sqlite lock table is considered to be left over by a crashed instance
+const staleLockAge = 10 * time.Minute
+
+const lockRetryInterval = 100 * time.Millisecond
+
+type locker struct {
+	lock   func(ctx context.Context, conn *sql.Conn) error
+	unlock func(ctx context.Context, conn *sql.Conn) error
+}
+
+func getLocker(dialect string) (locker, error) {
+	switch dialect {
+	case Sqlite:
+		return locker{lock: lockSqlite, unlock: unlockSqlite}, nil
+	case Postgres:
+		return locker{lock: lockPostgres, unlock: unlockPostgres}, nil
+	}
+	return locker{}, fmt.Errorf("unsupported database dialect: %s", dialect)
+}
+
+// withLock
+// Run the given function on a dedicated connection, while holding a lock that prevents other instances from
+// migrating the same database concurrently
+func withLock(ctx context.Context, db *sql.DB, dialect string, f func(conn *sql.Conn) error) error {
+	l, err := getLocker(dialect)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = l.lock(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	err = f(conn)
+	// the lock must be released, even if the context has been canceled in the meantime
+	return errors.Join(err, l.unlock(context.WithoutCancel(ctx), conn))
+}
+
+func lockPostgres(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, postgresLockId)
+	return err
+}
+
+func unlockPostgres(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, postgresLockId)
+	return err
+}
+
+func lockSqlite(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+    		id INTEGER PRIMARY KEY,
+    		locked_at BIGINT NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+	for {
+		_, err = conn.ExecContext(ctx, `DELETE FROM schema_migrations_lock WHERE locked_at < $1`,
+			time.Now().Add(-staleLockAge).Unix())
+		if err != nil {
+			return err
+		}
+		result, err := conn.ExecContext(ctx, `INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, $1) ON CONFLICT DO NOTHING`,
+			time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if inserted == 1 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+func unlockSqlite(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `DELETE FROM schema_migrations_lock WHERE id = 1`)
+	return err
+}
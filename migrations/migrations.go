@@ -0,0 +1,238 @@
+// Package migrations
+// applies the versioned database schema of go-drink. Every dialect (sqlite and postgres) has its own set of numbered
+// migrations in a subdirectory, each consisting of an up and a down script named <version>_<name>.(up|down).sql.
+// Applied versions are recorded in the schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	Sqlite   = "sqlite"
+	Postgres = "postgres"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var migrationFiles embed.FS
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt int64
+}
+
+// Load
+// Read all migrations available for the given dialect, ordered by their version
+func Load(dialect string) ([]Migration, error) {
+	if _, err := getLocker(dialect); err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(migrationFiles, dialect)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(migrationFiles, dialect+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("conflicting names for migration %d: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up
+// Apply all pending migrations of the given dialect
+func Up(ctx context.Context, db *sql.DB, dialect string) error {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return err
+	}
+	return withLock(ctx, db, dialect, func(conn *sql.Conn) error {
+		applied, err := getAppliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err = runMigration(ctx, conn, m.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+					m.Version, m.Name, time.Now().Unix())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down
+// Revert the given number of most recently applied migrations
+func Down(ctx context.Context, db *sql.DB, dialect string, steps int) error {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return err
+	}
+	return withLock(ctx, db, dialect, func(conn *sql.Conn) error {
+		applied, err := getAppliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s can not be reverted", m.Version, m.Name)
+			}
+			err = runMigration(ctx, conn, m.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error reverting migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// GetStatus
+// List all known migrations of the given dialect, together with the information whether they have been applied
+func GetStatus(ctx context.Context, db *sql.DB, dialect string) ([]Status, error) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	applied, err := getAppliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Status, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		result = append(result, Status{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return result, nil
+}
+
+// HasPending
+// Check whether there are migrations of the given dialect that have not been applied yet
+func HasPending(ctx context.Context, db *sql.DB, dialect string) (bool, error) {
+	status, err := GetStatus(ctx, db, dialect)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range status {
+		if !s.Applied {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func runMigration(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	err = record(tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func getAppliedVersions(ctx context.Context, conn *sql.Conn) (map[int]int64, error) {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    		version INTEGER PRIMARY KEY,
+    		name VARCHAR (255) NOT NULL,
+    		applied_at BIGINT NOT NULL
+		)`)
+	if err != nil {
+		return nil, err
+	}
+	result, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	applied := make(map[int]int64)
+	for result.Next() {
+		var version int
+		var appliedAt int64
+		err = result.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, result.Err()
+}
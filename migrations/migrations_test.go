@@ -0,0 +1,185 @@
+package migrations_test
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/Port39/go-drink/migrations"
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestLoad(t *testing.T) {
+	for _, dialect := range []string{migrations.Sqlite, migrations.Postgres} {
+		loaded, err := migrations.Load(dialect)
+		testutils.FailOnError(err, t)
+		testutils.ExpectSuccess(len(loaded) > 0, t)
+		for i, m := range loaded {
+			// versions must be consecutive, and every migration must be revertible
+			testutils.ExpectEqual(m.Version, i+1, t)
+			testutils.ExpectSuccess(m.Up != "", t)
+			testutils.ExpectSuccess(m.Down != "", t)
+		}
+	}
+
+	// both dialects must provide the same migrations
+	sqliteMigrations, _ := migrations.Load(migrations.Sqlite)
+	postgresMigrations, _ := migrations.Load(migrations.Postgres)
+	testutils.ExpectEqual(len(sqliteMigrations), len(postgresMigrations), t)
+	for i := range sqliteMigrations {
+		testutils.ExpectEqual(sqliteMigrations[i].Name, postgresMigrations[i].Name, t)
+	}
+
+	_, err := migrations.Load("mysql")
+	testutils.ExpectErrorWithMessage(err, "unsupported database dialect: mysql", t)
+}
+
+func TestUpAndDown(t *testing.T) {
+	db := testutils.GetEmptyDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	pending, err := migrations.HasPending(ctx, db, migrations.Sqlite)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(pending, t)
+
+	testutils.FailOnError(migrations.Up(ctx, db, migrations.Sqlite), t)
+	pending, err = migrations.HasPending(ctx, db, migrations.Sqlite)
+	testutils.FailOnError(err, t)
+	testutils.ExpectFailure(pending, t)
+
+	// applying the migrations a second time does nothing
+	testutils.FailOnError(migrations.Up(ctx, db, migrations.Sqlite), t)
+
+	_, err = db.ExecContext(ctx, `SELECT id, name, price, amount FROM items`)
+	testutils.FailOnError(err, t)
+
+	status, err := migrations.GetStatus(ctx, db, migrations.Sqlite)
+	testutils.FailOnError(err, t)
+	latest := status[len(status)-1].Version
+
+	testutils.FailOnError(migrations.Down(ctx, db, migrations.Sqlite, 1), t)
+	status, err = migrations.GetStatus(ctx, db, migrations.Sqlite)
+	testutils.FailOnError(err, t)
+	testutils.ExpectFailure(status[len(status)-1].Applied, t)
+	testutils.ExpectEqual(status[len(status)-1].Version, latest, t)
+
+	// reverting everything removes all tables
+	testutils.FailOnError(migrations.Down(ctx, db, migrations.Sqlite, latest), t)
+	_, err = db.ExecContext(ctx, `SELECT id FROM items`)
+	testutils.ExpectError(err, t)
+
+	// and everything can be applied again afterwards
+	testutils.FailOnError(migrations.Up(ctx, db, migrations.Sqlite), t)
+	pending, err = migrations.HasPending(ctx, db, migrations.Sqlite)
+	testutils.FailOnError(err, t)
+	testutils.ExpectFailure(pending, t)
+}
+
+func TestAdoptExistingSchema(t *testing.T) {
+	db := testutils.GetEmptyDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	// databases created before the introduction of migrations already contain the tables
+	_, err := db.ExecContext(ctx, `CREATE TABLE items (
+    		id VARCHAR (36) PRIMARY KEY,
+    		name VARCHAR (64) UNIQUE NOT NULL,
+    		price INTEGER,
+    		image bytea,
+    		amount INTEGER,
+    		barcode VARCHAR (128)
+		)`)
+	testutils.FailOnError(err, t)
+	_, err = db.ExecContext(ctx, `INSERT INTO items (id, name, price, image, amount, barcode) VALUES
+		('1', 'Mate', 150, NULL, 20, ''), ('4', 'Club-Mate', 150, NULL, 20, '4029764001807'),
+		('5', 'Cola', 120, NULL, 20, '036000291452')`)
+	testutils.FailOnError(err, t)
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE users (
+    		id VARCHAR (36) PRIMARY KEY,
+    		username VARCHAR (64) UNIQUE NOT NULL,
+    		email VARCHAR (64),
+    		role VARCHAR (16),
+    		credit INTEGER
+		)`)
+	testutils.FailOnError(err, t)
+	_, err = db.ExecContext(ctx, `INSERT INTO users (id, username, email, role, credit) VALUES ('2', 'alice', '', 'user', 500)`)
+	testutils.FailOnError(err, t)
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE transactions (
+    		id VARCHAR (36) PRIMARY KEY,
+    		itemId VARCHAR (36),
+    		userId VARCHAR (36),
+    		amount INTEGER,
+    		authBackend VARCHAR (16),
+    		timestamp INTEGER
+		)`)
+	testutils.FailOnError(err, t)
+	_, err = db.ExecContext(ctx, `INSERT INTO transactions (id, itemId, userId, amount, authBackend, timestamp) VALUES ('3', '1', '2', 1, 'password', 0)`)
+	testutils.FailOnError(err, t)
+
+	testutils.FailOnError(migrations.Up(ctx, db, migrations.Sqlite), t)
+
+	var name string
+	testutils.FailOnError(db.QueryRowContext(ctx, `SELECT name FROM items WHERE id = '1'`).Scan(&name), t)
+	testutils.ExpectEqual(name, "Mate", t)
+
+	// existing credits are booked as opening balances in the ledger
+	var id string
+	var money int
+	testutils.FailOnError(db.QueryRowContext(ctx, `SELECT id, money FROM transactions WHERE userId = '2' AND type = 'correction'`).
+		Scan(&id, &money), t)
+	testutils.ExpectEqual(money, 500, t)
+	testutils.ExpectEqual(len(id), 36, t)
+
+	// purchases recorded before the ledger have no known price, but keep the name of the item
+	var itemName string
+	var unitPrice sql.NullInt64
+	testutils.FailOnError(db.QueryRowContext(ctx, `SELECT itemName, unitPrice FROM transactions WHERE id = '3'`).
+		Scan(&itemName, &unitPrice), t)
+	testutils.ExpectEqual(itemName, "Mate", t)
+	testutils.ExpectFailure(unitPrice.Valid, t)
+
+	// barcodes are moved to their own table
+	var itemId string
+	testutils.FailOnError(db.QueryRowContext(ctx, `SELECT item_id FROM item_barcodes WHERE barcode = '4029764001807'`).
+		Scan(&itemId), t)
+	testutils.ExpectEqual(itemId, "4", t)
+	var barcodes int
+	testutils.FailOnError(db.QueryRowContext(ctx, `SELECT count(*) FROM item_barcodes`).Scan(&barcodes), t)
+	testutils.ExpectEqual(barcodes, 2, t)
+	// UPC-A codes are stored as EAN-13
+	testutils.FailOnError(db.QueryRowContext(ctx, `SELECT item_id FROM item_barcodes WHERE barcode = '0036000291452'`).
+		Scan(&itemId), t)
+	testutils.ExpectEqual(itemId, "5", t)
+}
+
+func TestConcurrentUp(t *testing.T) {
+	db := testutils.GetFileDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- migrations.Up(ctx, db, migrations.Sqlite)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		testutils.FailOnError(err, t)
+	}
+
+	var applied int
+	testutils.FailOnError(db.QueryRowContext(ctx, `SELECT count(*) FROM schema_migrations`).Scan(&applied), t)
+	loaded, _ := migrations.Load(migrations.Sqlite)
+	testutils.ExpectEqual(applied, len(loaded), t)
+}
@@ -0,0 +1,3 @@
+DROP INDEX transactions_reverses;
+
+ALTER TABLE transactions DROP COLUMN reverses;
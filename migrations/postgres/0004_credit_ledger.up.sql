@@ -0,0 +1,17 @@
+-- Every change of a user's credit is recorded as a typed transaction, money being the signed change of the credit.
+-- Purchases recorded before the introduction of the ledger have no money amount.
+ALTER TABLE transactions ADD COLUMN type VARCHAR (16) NOT NULL DEFAULT 'purchase';
+ALTER TABLE transactions ADD COLUMN money INTEGER;
+ALTER TABLE transactions ADD COLUMN counterpart VARCHAR (36);
+ALTER TABLE transactions ADD COLUMN note VARCHAR (255);
+
+UPDATE transactions SET type = 'refund' WHERE reverses IS NOT NULL;
+
+-- The existing credit of every user is booked as an opening balance, so the ledger matches the credits.
+INSERT INTO transactions (id, itemId, userId, amount, authBackend, timestamp, type, money, note)
+SELECT gen_random_uuid()::text, NULL, id, 0, 'system', CAST(EXTRACT(EPOCH FROM now()) AS INTEGER), 'correction', credit,
+       'opening balance'
+FROM users
+WHERE id <> '00000000-0000-0000-0000-000000000000' AND credit IS NOT NULL AND credit <> 0;
+
+CREATE INDEX transactions_userid ON transactions (userId);
@@ -0,0 +1,3 @@
+ALTER TABLE transactions DROP COLUMN total;
+ALTER TABLE transactions DROP COLUMN unitPrice;
+ALTER TABLE transactions DROP COLUMN itemName;
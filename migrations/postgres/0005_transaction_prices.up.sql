@@ -0,0 +1,11 @@
+-- Purchases and refunds keep a snapshot of the item's name and price, so historic revenue stays correct after the
+-- item is changed. The price is not known for purchases recorded before the ledger, so these keep NULL values.
+ALTER TABLE transactions ADD COLUMN itemName VARCHAR (64);
+ALTER TABLE transactions ADD COLUMN unitPrice INTEGER;
+ALTER TABLE transactions ADD COLUMN total INTEGER;
+
+UPDATE transactions SET itemName = (SELECT name FROM items WHERE items.id = transactions.itemId)
+WHERE itemId IS NOT NULL;
+
+UPDATE transactions SET unitPrice = -money / amount, total = -money
+WHERE type IN ('purchase', 'refund') AND money IS NOT NULL AND amount <> 0;
@@ -0,0 +1,2 @@
+-- Archived items are no longer sold, but kept so that historic transactions can still be resolved
+ALTER TABLE items ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE;
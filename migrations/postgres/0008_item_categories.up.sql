@@ -0,0 +1,15 @@
+-- Items can be assigned to one category, categories are shown in the order of their position
+CREATE TABLE categories (
+    id VARCHAR (36) PRIMARY KEY,
+    name VARCHAR (64) UNIQUE NOT NULL,
+    position INTEGER NOT NULL
+);
+
+ALTER TABLE items ADD COLUMN category VARCHAR (36);
+
+-- Free tags of the items, e.g. "vegan" or "caffeine"
+CREATE TABLE item_tags (
+    item_id VARCHAR (36) NOT NULL,
+    tag VARCHAR (32) NOT NULL,
+    PRIMARY KEY (item_id, tag)
+);
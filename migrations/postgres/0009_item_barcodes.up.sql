@@ -0,0 +1,16 @@
+-- An item can have several barcodes, e.g. for different bottle sizes or for a crate. Scanning a barcode with a
+-- multiplier stands for that many units of the item.
+CREATE TABLE item_barcodes (
+    barcode VARCHAR (128) PRIMARY KEY,
+    item_id VARCHAR (36) NOT NULL,
+    multiplier INTEGER NOT NULL
+);
+
+CREATE INDEX item_barcodes_item_id ON item_barcodes (item_id);
+
+-- if several items share a barcode, it is kept for an item which is still sold
+INSERT INTO item_barcodes (barcode, item_id, multiplier)
+SELECT barcode, id, 1 FROM items WHERE barcode IS NOT NULL AND barcode <> '' ORDER BY archived
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE items DROP COLUMN barcode;
@@ -0,0 +1,5 @@
+-- UPC-A codes are stored as the equivalent EAN-13 code with a leading zero, so scanners find the same item in
+-- either mode. ltrim removes all digits, so only numeric codes are changed.
+UPDATE item_barcodes SET barcode = '0' || barcode
+WHERE length(barcode) = 12 AND ltrim(barcode, '0123456789') = ''
+  AND NOT EXISTS (SELECT 1 FROM item_barcodes other WHERE other.barcode = '0' || item_barcodes.barcode);
@@ -0,0 +1,14 @@
+-- Changes of the stock which are not sales, like restocks and corrections. Sales and refunds are kept as transactions.
+CREATE TABLE stock_movements (
+    id VARCHAR (36) PRIMARY KEY,
+    item_id VARCHAR (36) NOT NULL,
+    type VARCHAR (16) NOT NULL,
+    amount INTEGER NOT NULL,
+    user_id VARCHAR (36) NOT NULL,
+    unit_cost INTEGER,
+    supplier VARCHAR (64),
+    delivery_id VARCHAR (36),
+    timestamp BIGINT NOT NULL
+);
+
+CREATE INDEX stock_movements_item_id ON stock_movements (item_id);
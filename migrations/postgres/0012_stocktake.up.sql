@@ -0,0 +1,3 @@
+-- Shrinkage found by a stocktake keeps the sale price of the item, so the loss can be reported later on.
+ALTER TABLE stock_movements ADD COLUMN unit_price INTEGER;
+ALTER TABLE stock_movements ADD COLUMN stocktake_id VARCHAR (36);
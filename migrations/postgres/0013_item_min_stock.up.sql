@@ -0,0 +1,2 @@
+-- An item is low on stock once its amount falls below the minimum stock, zero disables the notification.
+ALTER TABLE items ADD COLUMN min_stock INTEGER NOT NULL DEFAULT 0;
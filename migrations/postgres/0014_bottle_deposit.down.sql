@@ -0,0 +1,2 @@
+ALTER TABLE transactions DROP COLUMN unitDeposit;
+ALTER TABLE items DROP COLUMN deposit;
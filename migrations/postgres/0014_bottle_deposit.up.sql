@@ -0,0 +1,4 @@
+-- The deposit (Pfand) of an item is charged on top of its price. Purchases, refunds and returned empties keep the
+-- deposit per unit, so the outstanding deposits can be calculated from the ledger.
+ALTER TABLE items ADD COLUMN deposit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN unitDeposit INTEGER;
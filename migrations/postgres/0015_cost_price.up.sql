@@ -0,0 +1,7 @@
+-- The cost price is the weighted average purchase cost of the items in stock. Purchases keep the cost price at the
+-- time of the sale, so the margin can be calculated from the ledger.
+ALTER TABLE items ADD COLUMN cost_price INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN unitCost INTEGER;
+
+UPDATE items SET cost_price = COALESCE((SELECT SUM(amount * unit_cost) / SUM(amount) FROM stock_movements
+    WHERE stock_movements.item_id = items.id AND type = 'restock'), 0);
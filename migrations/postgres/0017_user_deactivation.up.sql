@@ -0,0 +1,2 @@
+-- Deactivated users can't log in anymore, but are kept with their ledger
+ALTER TABLE users ADD COLUMN deactivated BOOLEAN NOT NULL DEFAULT FALSE;
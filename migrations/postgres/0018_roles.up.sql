@@ -0,0 +1,30 @@
+-- Roles group named permissions. Admins have every permission, so their permissions are not stored.
+CREATE TABLE roles (
+    name VARCHAR (16) PRIMARY KEY
+);
+
+CREATE TABLE role_permissions (
+    role VARCHAR (16) NOT NULL,
+    permission VARCHAR (32) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name) VALUES ('admin'), ('user'), ('restocker'), ('treasurer');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('user', 'items:buy'),
+    ('user', 'account:own'),
+    ('restocker', 'items:buy'),
+    ('restocker', 'account:own'),
+    ('restocker', 'stock:read'),
+    ('restocker', 'stock:restock'),
+    ('treasurer', 'items:buy'),
+    ('treasurer', 'account:own'),
+    ('treasurer', 'users:read'),
+    ('treasurer', 'credit:adjust'),
+    ('treasurer', 'transactions:read'),
+    ('treasurer', 'transactions:refund'),
+    ('treasurer', 'reports:read');
+
+-- roles users have been given by hand are kept, without any permissions
+INSERT INTO roles (name) SELECT DISTINCT role FROM users WHERE role IS NOT NULL AND role NOT IN (SELECT name FROM roles);
@@ -0,0 +1,2 @@
+DROP TABLE email_verification;
+ALTER TABLE users DROP COLUMN verified;
@@ -0,0 +1 @@
+ALTER TABLE email_verification DROP COLUMN changes_email;
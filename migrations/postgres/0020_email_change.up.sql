@@ -0,0 +1,2 @@
+-- a new address only replaces the current one once the token mailed to it has been sent back
+ALTER TABLE email_verification ADD COLUMN changes_email BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+DROP TABLE transactions;
+DROP TABLE password_reset;
+DROP TABLE auth;
+DROP TABLE users;
+DROP TABLE items;
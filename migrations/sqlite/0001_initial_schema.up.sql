@@ -0,0 +1,40 @@
+-- The tables are created only if they do not exist yet, so that databases which were set up before the introduction
+-- of versioned migrations can be adopted as they are.
+CREATE TABLE IF NOT EXISTS items (
+    id VARCHAR (36) PRIMARY KEY,
+    name VARCHAR (64) UNIQUE NOT NULL,
+    price INTEGER,
+    image bytea,
+    amount INTEGER,
+    barcode VARCHAR (128)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR (36) PRIMARY KEY,
+    username VARCHAR (64) UNIQUE NOT NULL,
+    email VARCHAR (64),
+    role VARCHAR (16),
+    credit INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS auth (
+    user_id VARCHAR (36) NOT NULL,
+    type VARCHAR (16) NOT NULL,
+    data bytea,
+    PRIMARY KEY (user_id, type)
+);
+
+CREATE TABLE IF NOT EXISTS password_reset (
+    user_id VARCHAR (36) UNIQUE NOT NULL,
+    token VARCHAR (36) PRIMARY KEY,
+    valid_until BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id VARCHAR (36) PRIMARY KEY,
+    itemId VARCHAR (36),
+    userId VARCHAR (36),
+    amount INTEGER,
+    authBackend VARCHAR (16),
+    timestamp INTEGER
+);
@@ -0,0 +1,9 @@
+CREATE TABLE sessions (
+    id VARCHAR (36) PRIMARY KEY,
+    user_id VARCHAR (36) NOT NULL,
+    role VARCHAR (16),
+    auth_backend VARCHAR (16),
+    not_valid_after BIGINT NOT NULL
+);
+
+CREATE INDEX sessions_not_valid_after ON sessions (not_valid_after);
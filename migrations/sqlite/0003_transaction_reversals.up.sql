@@ -0,0 +1,4 @@
+-- a compensating transaction references the transaction it reverses. Every transaction can be reversed only once.
+ALTER TABLE transactions ADD COLUMN reverses VARCHAR (36);
+
+CREATE UNIQUE INDEX transactions_reverses ON transactions (reverses);
@@ -0,0 +1,8 @@
+DROP INDEX transactions_userid;
+
+DELETE FROM transactions WHERE type NOT IN ('purchase', 'refund');
+
+ALTER TABLE transactions DROP COLUMN note;
+ALTER TABLE transactions DROP COLUMN counterpart;
+ALTER TABLE transactions DROP COLUMN money;
+ALTER TABLE transactions DROP COLUMN type;
@@ -0,0 +1,8 @@
+-- Resized versions of the item images, generated when they are requested for the first time
+CREATE TABLE item_thumbnails (
+    item_id VARCHAR (36) NOT NULL,
+    size INTEGER NOT NULL,
+    content_type VARCHAR (64) NOT NULL,
+    data bytea NOT NULL,
+    PRIMARY KEY (item_id, size)
+);
@@ -0,0 +1,3 @@
+DROP TABLE item_tags;
+ALTER TABLE items DROP COLUMN category;
+DROP TABLE categories;
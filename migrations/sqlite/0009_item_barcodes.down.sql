@@ -0,0 +1,5 @@
+ALTER TABLE items ADD COLUMN barcode VARCHAR (128);
+
+UPDATE items SET barcode = (SELECT MIN(barcode) FROM item_barcodes WHERE item_barcodes.item_id = items.id);
+
+DROP TABLE item_barcodes;
@@ -0,0 +1,3 @@
+UPDATE item_barcodes SET barcode = substr(barcode, 2)
+WHERE length(barcode) = 13 AND substr(barcode, 1, 1) = '0' AND ltrim(barcode, '0123456789') = ''
+  AND NOT EXISTS (SELECT 1 FROM item_barcodes other WHERE other.barcode = substr(item_barcodes.barcode, 2));
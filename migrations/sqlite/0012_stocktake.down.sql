@@ -0,0 +1,2 @@
+ALTER TABLE stock_movements DROP COLUMN stocktake_id;
+ALTER TABLE stock_movements DROP COLUMN unit_price;
@@ -0,0 +1,2 @@
+ALTER TABLE transactions DROP COLUMN unitCost;
+ALTER TABLE items DROP COLUMN cost_price;
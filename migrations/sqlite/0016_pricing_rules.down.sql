@@ -0,0 +1,2 @@
+ALTER TABLE transactions DROP COLUMN pricingRule;
+DROP TABLE pricing_rules;
@@ -0,0 +1,18 @@
+-- Pricing rules replace the regular price of items for some buyers or at some times. The rule applied to a purchase
+-- is kept on the transaction.
+CREATE TABLE pricing_rules (
+    id VARCHAR (36) PRIMARY KEY,
+    name VARCHAR (64) NOT NULL,
+    type VARCHAR (16) NOT NULL,
+    value INTEGER NOT NULL,
+    item_id VARCHAR (36),
+    category_id VARCHAR (36),
+    role VARCHAR (16),
+    valid_from BIGINT,
+    valid_until BIGINT,
+    daily_start VARCHAR (5),
+    daily_end VARCHAR (5),
+    priority INTEGER NOT NULL DEFAULT 0
+);
+
+ALTER TABLE transactions ADD COLUMN pricingRule VARCHAR (36);
@@ -0,0 +1,11 @@
+-- Password reset mails are only sent to verified addresses. Addresses given before are trusted, so nobody is locked out.
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET verified = TRUE WHERE email IS NOT NULL AND email <> '';
+
+-- the address is kept with the token, so a token can't verify an address it hasn't been sent to
+CREATE TABLE email_verification (
+    user_id VARCHAR (36) PRIMARY KEY,
+    email VARCHAR (64) NOT NULL,
+    token VARCHAR (36) UNIQUE NOT NULL,
+    valid_until BIGINT NOT NULL
+);
@@ -0,0 +1,168 @@
+package pagination
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+var (
+	ErrInvalidLimit  = errors.New("limit must be between 1 and " + strconv.Itoa(MaxLimit))
+	ErrInvalidSort   = errors.New("invalid sort order")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Cursor
+// Position in a sorted list. The next page starts with the row following the one with the given sort value and id, so
+// pages stay stable while rows are added. The sort key is kept to reject cursors of a differently sorted list.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value any    `json:"v"`
+	Id    string `json:"id"`
+}
+
+func (c Cursor) String() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var c Cursor
+	err = decoder.Decode(&c)
+	if err != nil || c.Id == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	switch v := c.Value.(type) {
+	case json.Number:
+		// sort values are either integers or strings
+		c.Value, err = v.Int64()
+		if err != nil {
+			return Cursor{}, ErrInvalidCursor
+		}
+	case string:
+	default:
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Page
+// Which part of a list should be retrieved. Sort is the key the list is sorted by, as accepted by the endpoint.
+type Page struct {
+	Limit      int
+	Sort       string
+	Descending bool
+	After      *Cursor
+	column     string
+}
+
+// FromQuery
+// Read the page from the query parameters limit, sort and cursor. Sorts maps the sort keys accepted for the list to
+// their columns. A sort key may be prefixed with "-" for descending order, e.g. "-timestamp".
+func FromQuery(query url.Values, sorts map[string]string, defaultSort string) (Page, error) {
+	page := Page{Limit: DefaultLimit}
+	if query.Has("limit") {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return Page{}, ErrInvalidLimit
+		}
+		page.Limit = limit
+	}
+
+	sort := defaultSort
+	if query.Has("sort") {
+		sort = query.Get("sort")
+	}
+	page.Descending = strings.HasPrefix(sort, "-")
+	page.Sort = strings.TrimPrefix(sort, "-")
+	column, ok := sorts[page.Sort]
+	if !ok {
+		return Page{}, ErrInvalidSort
+	}
+	page.column = column
+
+	if query.Has("cursor") {
+		c, err := ParseCursor(query.Get("cursor"))
+		if err != nil || c.Sort != sort {
+			return Page{}, ErrInvalidCursor
+		}
+		page.After = &c
+	}
+	return page, nil
+}
+
+// Query
+// Builds the conditions and arguments of a paginated query
+type Query struct {
+	conditions []string
+	args       []any
+}
+
+// Arg
+// Add an argument to the query, and get its placeholder
+func (q *Query) Arg(value any) string {
+	q.args = append(q.args, value)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// Where
+// Add a condition, all conditions must be met by the rows of the result
+func (q *Query) Where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+// Build
+// Get the paginated query for the given select statement, which must not contain a WHERE clause. The id column breaks
+// ties between rows with equal sort values. Rows with a NULL sort value never compare as before or after the cursor,
+// so nullable sort columns have to be wrapped in COALESCE, and selected the same way. One more row than requested is
+// fetched, to find out whether there is a next page (see Trim).
+func (q *Query) Build(selectFrom, idColumn string, page Page) (string, []any) {
+	order, comparison := "ASC", ">"
+	if page.Descending {
+		order, comparison = "DESC", "<"
+	}
+	conditions := q.conditions
+	if page.After != nil {
+		value, id := q.Arg(page.After.Value), q.Arg(page.After.Id)
+		conditions = append(conditions, "("+page.column+" "+comparison+" "+value+" OR ("+page.column+" = "+value+
+			" AND "+idColumn+" "+comparison+" "+id+"))")
+	}
+	query := selectFrom
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY " + page.column + " " + order + ", " + idColumn + " " + order +
+		" LIMIT " + strconv.Itoa(page.Limit+1)
+	return query, q.args
+}
+
+// Trim
+// Cut the result of a query built by Build to the requested size. If there are more rows, the cursor pointing to the
+// next page is returned as well. Key returns the sort value and the id of a row.
+func Trim[T any](rows []T, page Page, key func(row T) (any, string)) ([]T, *Cursor) {
+	if len(rows) <= page.Limit {
+		return rows, nil
+	}
+	rows = rows[:page.Limit]
+	value, id := key(rows[page.Limit-1])
+	sort := page.Sort
+	if page.Descending {
+		sort = "-" + sort
+	}
+	return rows, &Cursor{Sort: sort, Value: value, Id: id}
+}
@@ -0,0 +1,95 @@
+package pagination
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/Port39/go-drink/testutils"
+)
+
+var testSorts = map[string]string{
+	"name":  "name",
+	"price": "price",
+}
+
+func TestFromQuery(t *testing.T) {
+	page, err := FromQuery(url.Values{}, testSorts, "name")
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(page.Limit, DefaultLimit, t)
+	testutils.ExpectEqual(page.Sort, "name", t)
+	testutils.ExpectFailure(page.Descending, t)
+	testutils.ExpectSuccess(page.After == nil, t)
+
+	page, err = FromQuery(url.Values{"sort": {"-price"}, "limit": {"5"}}, testSorts, "name")
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(page.Limit, 5, t)
+	testutils.ExpectEqual(page.Sort, "price", t)
+	testutils.ExpectSuccess(page.Descending, t)
+
+	for _, limit := range []string{"0", "-1", "1001", "many"} {
+		_, err = FromQuery(url.Values{"limit": {limit}}, testSorts, "name")
+		testutils.ExpectSuccess(errors.Is(err, ErrInvalidLimit), t)
+	}
+	_, err = FromQuery(url.Values{"sort": {"image"}}, testSorts, "name")
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidSort), t)
+	_, err = FromQuery(url.Values{"cursor": {"not a cursor"}}, testSorts, "name")
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidCursor), t)
+
+	// cursors of a differently sorted list are rejected
+	cursor := Cursor{Sort: "-price", Value: int64(150), Id: "1"}
+	_, err = FromQuery(url.Values{"cursor": {cursor.String()}}, testSorts, "name")
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidCursor), t)
+	page, err = FromQuery(url.Values{"cursor": {cursor.String()}, "sort": {"-price"}}, testSorts, "name")
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(*page.After, cursor, t)
+}
+
+func TestQuery(t *testing.T) {
+	db := testutils.GetEmptyDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `CREATE TABLE things (id VARCHAR (36) PRIMARY KEY, name VARCHAR (64), price INTEGER)`)
+	testutils.FailOnError(err, t)
+	_, err = db.ExecContext(ctx, `INSERT INTO things (id, name, price) VALUES
+		('1', 'a', 100), ('2', 'b', 200), ('3', 'c', 100), ('4', 'd', 300), ('5', 'e', 100)`)
+	testutils.FailOnError(err, t)
+
+	type thing struct {
+		id    string
+		price int
+	}
+	query := url.Values{"sort": {"price"}, "limit": {"2"}}
+	ids := make([]string, 0)
+	for {
+		page, err := FromQuery(query, testSorts, "name")
+		testutils.FailOnError(err, t)
+		var q Query
+		q.Where("price < " + q.Arg(300))
+		statement, args := q.Build(`SELECT id, price FROM things`, "id", page)
+		result, err := db.QueryContext(ctx, statement, args...)
+		testutils.FailOnError(err, t)
+		things := make([]thing, 0)
+		for result.Next() {
+			var th thing
+			testutils.FailOnError(result.Scan(&th.id, &th.price), t)
+			things = append(things, th)
+		}
+		testutils.FailOnError(result.Close(), t)
+
+		things, next := Trim(things, page, func(th thing) (any, string) { return th.price, th.id })
+		for _, th := range things {
+			ids = append(ids, th.id)
+		}
+		if next == nil {
+			break
+		}
+		query.Set("cursor", next.String())
+	}
+	testutils.ExpectEqual(len(ids), 4, t)
+	for i, id := range []string{"1", "3", "5", "2"} {
+		testutils.ExpectEqual(ids[i], id, t)
+	}
+}
@@ -0,0 +1,237 @@
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/users"
+)
+
+const (
+	// TypeFixed replaces the price of the item, the value is the unit price in cents
+	TypeFixed = "fixed"
+	// TypePercentage charges a percentage of the price, e.g. 80 for a discount of 20% or 120 for a surcharge of 20%
+	TypePercentage = "percentage"
+	// TypeBundle makes every unit after the value free, i.e. a value of 5 means "buy 5, get one free"
+	TypeBundle = "bundle"
+)
+
+var Types = []string{TypeFixed, TypePercentage, TypeBundle}
+
+// RoleGuest
+// Rules for guests apply to cash payments, i.e. to buyers without an account
+const RoleGuest = "guest"
+
+var ErrNoSuchRule = errors.New("no such pricing rule")
+
+// Rule
+// A pricing rule applies to an item, the items of a category, or all items if neither is set. It can be restricted to
+// buyers with a role, where rules for the user role apply to everyone with an account, to a period between ValidFrom
+// and ValidUntil (unix timestamps), and to the same hours of every day between DailyStart and DailyEnd ("HH:MM" in the
+// local time of the server). Daily hours may span midnight, e.g. from "22:00" to "02:00".
+type Rule struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Value      int    `json:"value"`
+	ItemId     string `json:"itemId,omitempty"`
+	CategoryId string `json:"categoryId,omitempty"`
+	Role       string `json:"role,omitempty"`
+	ValidFrom  int64  `json:"validFrom,omitempty"`
+	ValidUntil int64  `json:"validUntil,omitempty"`
+	DailyStart string `json:"dailyStart,omitempty"`
+	DailyEnd   string `json:"dailyEnd,omitempty"`
+	Priority   int    `json:"priority"`
+}
+
+const ruleColumns = "id, name, type, value, item_id, category_id, role, valid_from, valid_until, daily_start, daily_end, priority"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRule(row scanner) (Rule, error) {
+	var rule Rule
+	var itemId, categoryId, role, dailyStart, dailyEnd sql.NullString
+	var validFrom, validUntil sql.NullInt64
+	err := row.Scan(&rule.Id, &rule.Name, &rule.Type, &rule.Value, &itemId, &categoryId, &role, &validFrom,
+		&validUntil, &dailyStart, &dailyEnd, &rule.Priority)
+	rule.ItemId, rule.CategoryId, rule.Role = itemId.String, categoryId.String, role.String
+	rule.ValidFrom, rule.ValidUntil = validFrom.Int64, validUntil.Int64
+	rule.DailyStart, rule.DailyEnd = dailyStart.String, dailyEnd.String
+	return rule, err
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryRules(ctx context.Context, q queryer, condition string, args ...any) ([]Rule, error) {
+	rules := make([]Rule, 0)
+	result, err := q.QueryContext(ctx, "SELECT "+ruleColumns+" FROM pricing_rules "+condition+
+		" ORDER BY priority DESC, name", args...)
+	if err != nil {
+		return rules, err
+	}
+	defer result.Close()
+	for result.Next() {
+		rule, err := scanRule(result)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, result.Err()
+}
+
+// GetRules
+// All pricing rules, including the expired ones, ordered by their priority
+func GetRules(ctx context.Context, db *sql.DB) ([]Rule, error) {
+	return queryRules(ctx, db, "")
+}
+
+// GetRulesAtWithTransaction
+// The pricing rules which are valid at the given time, without checking their daily hours
+func GetRulesAtWithTransaction(ctx context.Context, at time.Time, tx *sql.Tx) ([]Rule, error) {
+	return queryRules(ctx, tx, `WHERE (valid_from IS NULL OR valid_from <= $1) AND (valid_until IS NULL OR valid_until > $1)`,
+		at.Unix())
+}
+
+func GetRuleById(ctx context.Context, id string, db *sql.DB) (Rule, error) {
+	rule, err := scanRule(db.QueryRowContext(ctx, "SELECT "+ruleColumns+" FROM pricing_rules WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Rule{}, ErrNoSuchRule
+	}
+	return rule, err
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullIfZero(i int64) any {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
+
+func InsertNewRule(ctx context.Context, rule Rule, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO pricing_rules (`+ruleColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`, rule.Id, rule.Name, rule.Type, rule.Value,
+		nullIfEmpty(rule.ItemId), nullIfEmpty(rule.CategoryId), nullIfEmpty(rule.Role), nullIfZero(rule.ValidFrom),
+		nullIfZero(rule.ValidUntil), nullIfEmpty(rule.DailyStart), nullIfEmpty(rule.DailyEnd), rule.Priority)
+	return err
+}
+
+func UpdateRule(ctx context.Context, rule Rule, db *sql.DB) error {
+	result, err := db.ExecContext(ctx, `UPDATE pricing_rules SET name = $1, type = $2, value = $3, item_id = $4,
+		category_id = $5, role = $6, valid_from = $7, valid_until = $8, daily_start = $9, daily_end = $10, priority = $11
+		WHERE id = $12`, rule.Name, rule.Type, rule.Value, nullIfEmpty(rule.ItemId), nullIfEmpty(rule.CategoryId),
+		nullIfEmpty(rule.Role), nullIfZero(rule.ValidFrom), nullIfZero(rule.ValidUntil), nullIfEmpty(rule.DailyStart),
+		nullIfEmpty(rule.DailyEnd), rule.Priority, rule.Id)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err == nil && updated == 0 {
+		return ErrNoSuchRule
+	}
+	return err
+}
+
+// DeleteRule
+// Delete the rule, purchases it has been applied to keep referring to it
+func DeleteRule(ctx context.Context, id string, db *sql.DB) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM pricing_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err == nil && deleted == 0 {
+		return ErrNoSuchRule
+	}
+	return err
+}
+
+// AppliesTo
+// Check whether the rule applies to a purchase of the item by the buyer at the given time
+func (rule *Rule) AppliesTo(item *items.Item, buyer *users.User, at time.Time) bool {
+	if rule.ItemId != "" && rule.ItemId != item.Id {
+		return false
+	}
+	if rule.CategoryId != "" && rule.CategoryId != item.CategoryId {
+		return false
+	}
+	if rule.Role == RoleGuest && !buyer.IsCashUser() {
+		return false
+	}
+	// the cash user has the user role, but only rules for guests or everyone apply to it
+	if rule.Role != "" && rule.Role != RoleGuest &&
+		(buyer.IsCashUser() || rule.Role != users.RoleUser && rule.Role != buyer.Role) {
+		return false
+	}
+	if rule.ValidFrom != 0 && at.Unix() < rule.ValidFrom || rule.ValidUntil != 0 && at.Unix() >= rule.ValidUntil {
+		return false
+	}
+	if rule.DailyStart == "" || rule.DailyEnd == "" {
+		return true
+	}
+	// zero padded times compare like the times they represent
+	now := at.Format("15:04")
+	if rule.DailyStart <= rule.DailyEnd {
+		return rule.DailyStart <= now && now < rule.DailyEnd
+	}
+	return rule.DailyStart <= now || now < rule.DailyEnd
+}
+
+// Quote
+// The price of a cart line, and the rule it has been calculated with, if any
+type Quote struct {
+	UnitPrice int
+	Total     int
+	Rule      *Rule
+}
+
+func (rule *Rule) quote(item *items.Item, amount int) Quote {
+	q := Quote{UnitPrice: item.Price, Rule: rule}
+	switch rule.Type {
+	case TypeFixed:
+		q.UnitPrice = rule.Value
+	case TypePercentage:
+		q.UnitPrice = (item.Price*rule.Value + 50) / 100
+	case TypeBundle:
+		q.Total = item.Price * (amount - amount/(rule.Value+1))
+		return q
+	}
+	q.Total = q.UnitPrice * amount
+	return q
+}
+
+// Price
+// Calculate the price of buying amount units of the item. Of all rules applying to the purchase, the one with the
+// highest priority is used. If several rules share that priority, the buyer gets the cheapest price. Without any
+// rule, the regular price of the item is charged.
+func Price(rules []Rule, item *items.Item, amount int, buyer *users.User, at time.Time) Quote {
+	best := Quote{UnitPrice: item.Price, Total: item.Price * amount}
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.AppliesTo(item, buyer, at) {
+			continue
+		}
+		if best.Rule != nil && rule.Priority < best.Rule.Priority {
+			continue
+		}
+		q := rule.quote(item, amount)
+		if best.Rule == nil || rule.Priority > best.Rule.Priority || q.Total < best.Total {
+			best = q
+		}
+	}
+	return best
+}
@@ -0,0 +1,130 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/testutils"
+	"github.com/Port39/go-drink/users"
+)
+
+var (
+	mate   = items.Item{Id: "00000000-0000-0000-0000-00000000000a", Name: "Mate", Price: 150, CategoryId: "00000000-0000-0000-0000-0000000000c1"}
+	member = users.User{Id: "00000000-0000-0000-0000-000000000001", Role: "user"}
+	guest  = users.User{Id: users.CashUserId, Role: "user"}
+)
+
+func TestPrice(t *testing.T) {
+	now := time.Date(2026, 5, 1, 18, 30, 0, 0, time.Local)
+	rules := []Rule{
+		{Id: "guest", Type: TypePercentage, Value: 120, Role: RoleGuest},
+		{Id: "happy-hour", Type: TypePercentage, Value: 50, CategoryId: mate.CategoryId, DailyStart: "18:00", DailyEnd: "19:00"},
+		{Id: "bundle", Type: TypeBundle, Value: 5, ItemId: mate.Id},
+		{Id: "restocker", Type: TypeFixed, Value: 100, Role: "restocker"},
+		{Id: "expired", Type: TypeFixed, Value: 1, Priority: 10, ValidUntil: now.Unix()},
+	}
+
+	// the cheapest of the rules with the same priority is used
+	quote := Price(rules, &mate, 2, &member, now)
+	testutils.ExpectEqual(quote.Rule.Id, "happy-hour", t)
+	testutils.ExpectEqual(quote.UnitPrice, 75, t)
+	testutils.ExpectEqual(quote.Total, 150, t)
+	quote = Price(rules, &mate, 12, &member, now.Add(time.Hour))
+	testutils.ExpectEqual(quote.Rule.Id, "bundle", t)
+	testutils.ExpectEqual(quote.UnitPrice, 150, t)
+	testutils.ExpectEqual(quote.Total, 1500, t)
+	quote = Price(rules, &mate, 1, &member, now.Add(time.Hour))
+	testutils.ExpectEqual(quote.Rule.Id, "bundle", t)
+	testutils.ExpectEqual(quote.Total, 150, t)
+
+	// guests pay a surcharge, unless the rule with the surcharge has a lower priority than the discount
+	rules[0].Priority = 1
+	quote = Price(rules, &mate, 1, &guest, now)
+	testutils.ExpectEqual(quote.Rule.Id, "guest", t)
+	testutils.ExpectEqual(quote.Total, 180, t)
+	rules[0].Priority = 0
+	quote = Price(rules, &mate, 1, &guest, now)
+	testutils.ExpectEqual(quote.Rule.Id, "happy-hour", t)
+
+	// roles have to match exactly, except for rules for users, and the cash user only gets rules for guests
+	restocker := users.User{Id: "00000000-0000-0000-0000-000000000002", Role: "restocker"}
+	quote = Price(rules[3:], &mate, 1, &restocker, now)
+	testutils.ExpectEqual(quote.Rule.Id, "restocker", t)
+	quote = Price(rules[3:], &mate, 1, &member, now)
+	testutils.ExpectSuccess(quote.Rule == nil, t)
+	testutils.ExpectEqual(quote.Total, 150, t)
+	admin := users.User{Id: "00000000-0000-0000-0000-000000000003", Role: "admin"}
+	quote = Price(rules[3:], &mate, 1, &admin, now)
+	testutils.ExpectSuccess(quote.Rule == nil, t)
+	rules[3].Role = "user"
+	quote = Price(rules[3:], &mate, 1, &restocker, now)
+	testutils.ExpectEqual(quote.Rule.Id, "restocker", t)
+	quote = Price(rules[3:], &mate, 1, &guest, now)
+	testutils.ExpectSuccess(quote.Rule == nil, t)
+
+	// daily hours may span midnight
+	night := Rule{DailyStart: "22:00", DailyEnd: "02:00"}
+	testutils.ExpectSuccess(night.AppliesTo(&mate, &member, time.Date(2026, 5, 1, 23, 0, 0, 0, time.Local)), t)
+	testutils.ExpectSuccess(night.AppliesTo(&mate, &member, time.Date(2026, 5, 2, 1, 59, 0, 0, time.Local)), t)
+	testutils.ExpectSuccess(!night.AppliesTo(&mate, &member, time.Date(2026, 5, 2, 2, 0, 0, 0, time.Local)), t)
+}
+
+func TestRules(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	now := time.Now()
+	openDay := Rule{
+		Id:         "00000000-0000-0000-0000-0000000000e1",
+		Name:       "Open day",
+		Type:       TypePercentage,
+		Value:      120,
+		Role:       RoleGuest,
+		ValidFrom:  now.Unix() - 60,
+		ValidUntil: now.Unix() + 60,
+	}
+	happyHour := Rule{
+		Id:         "00000000-0000-0000-0000-0000000000e2",
+		Name:       "Happy hour",
+		Type:       TypePercentage,
+		Value:      50,
+		DailyStart: "18:00",
+		DailyEnd:   "19:00",
+		Priority:   1,
+	}
+	testutils.FailOnError(InsertNewRule(ctx, openDay, db), t)
+	testutils.FailOnError(InsertNewRule(ctx, happyHour, db), t)
+
+	rules, err := GetRules(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(rules), 2, t)
+	testutils.ExpectEqual(rules[0], happyHour, t)
+	testutils.ExpectEqual(rules[1], openDay, t)
+
+	tx, err := db.BeginTx(ctx, nil)
+	testutils.FailOnError(err, t)
+	rules, err = GetRulesAtWithTransaction(ctx, now.Add(time.Hour), tx)
+	testutils.FailOnError(err, t)
+	testutils.FailOnError(tx.Rollback(), t)
+	testutils.ExpectEqual(len(rules), 1, t)
+	testutils.ExpectEqual(rules[0].Id, happyHour.Id, t)
+
+	happyHour.Value = 70
+	happyHour.DailyStart, happyHour.DailyEnd = "", ""
+	testutils.FailOnError(UpdateRule(ctx, happyHour, db), t)
+	retrieved, err := GetRuleById(ctx, happyHour.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved, happyHour, t)
+
+	testutils.FailOnError(DeleteRule(ctx, happyHour.Id, db), t)
+	_, err = GetRuleById(ctx, happyHour.Id, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchRule), t)
+	err = DeleteRule(ctx, happyHour.Id, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchRule), t)
+	err = UpdateRule(ctx, happyHour, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchRule), t)
+}
@@ -0,0 +1,143 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Port39/go-drink/transactions"
+)
+
+// ItemMargin
+// The sales of an item in a period. The cost is calculated from the cost price at the time of each sale, units sold
+// before the cost price was tracked, or before the item was ever restocked, are counted as units with unknown cost.
+type ItemMargin struct {
+	ItemId      string `json:"itemId"`
+	ItemName    string `json:"itemName"`
+	Sold        int    `json:"sold"`
+	Revenue     int    `json:"revenue"`
+	Cost        int    `json:"cost"`
+	Margin      int    `json:"margin"`
+	UnknownCost int    `json:"unknownCost"`
+}
+
+type MarginReport struct {
+	Since   int64        `json:"since"`
+	Until   int64        `json:"until"`
+	Items   []ItemMargin `json:"items"`
+	Revenue int          `json:"revenue"`
+	Cost    int          `json:"cost"`
+	Margin  int          `json:"margin"`
+}
+
+// GetMargins
+// Sum up the revenue and cost of all purchases between since and until, net of refunds. Deposits are not revenue, so
+// they are left out. The items with the highest margin come first.
+func GetMargins(ctx context.Context, since, until int64, db *sql.DB) (MarginReport, error) {
+	report := MarginReport{Since: since, Until: until, Items: make([]ItemMargin, 0)}
+	result, err := db.QueryContext(ctx, `SELECT t.itemId, COALESCE(i.name, MAX(t.itemName)), SUM(t.amount),
+			SUM(COALESCE(t.total, 0)), SUM(t.amount * COALESCE(t.unitCost, 0)),
+			SUM(CASE WHEN COALESCE(t.unitCost, 0) = 0 THEN t.amount ELSE 0 END)
+		FROM transactions t LEFT JOIN items i ON i.id = t.itemId
+		WHERE t.type IN ($1, $2) AND t.timestamp > $3 AND t.timestamp < $4
+		GROUP BY t.itemId, i.name
+		ORDER BY SUM(COALESCE(t.total, 0)) - SUM(t.amount * COALESCE(t.unitCost, 0)) DESC`, transactions.TypePurchase, transactions.TypeRefund, since, until)
+	if err != nil {
+		return report, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var line ItemMargin
+		err = result.Scan(&line.ItemId, &line.ItemName, &line.Sold, &line.Revenue, &line.Cost, &line.UnknownCost)
+		if err != nil {
+			return report, err
+		}
+		line.Margin = line.Revenue - line.Cost
+		report.Items = append(report.Items, line)
+		report.Revenue += line.Revenue
+		report.Cost += line.Cost
+	}
+	report.Margin = report.Revenue - report.Cost
+	return report, result.Err()
+}
+
+// ItemValue
+// The value of the stock of an item at its cost price
+type ItemValue struct {
+	ItemId    string `json:"itemId"`
+	ItemName  string `json:"itemName"`
+	Amount    int    `json:"amount"`
+	CostPrice int    `json:"costPrice"`
+	Value     int    `json:"value"`
+}
+
+type InventoryValue struct {
+	Items []ItemValue `json:"items"`
+	Value int         `json:"value"`
+}
+
+// GetInventoryValue
+// The value of all items in stock at their cost price, including archived items which have not been sold off yet
+func GetInventoryValue(ctx context.Context, db *sql.DB) (InventoryValue, error) {
+	inventory := InventoryValue{Items: make([]ItemValue, 0)}
+	result, err := db.QueryContext(ctx, `SELECT id, name, amount, cost_price, amount * cost_price AS value FROM items
+		WHERE amount > 0 ORDER BY value DESC, name`)
+	if err != nil {
+		return inventory, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var line ItemValue
+		err = result.Scan(&line.ItemId, &line.ItemName, &line.Amount, &line.CostPrice, &line.Value)
+		if err != nil {
+			return inventory, err
+		}
+		inventory.Items = append(inventory.Items, line)
+		inventory.Value += line.Value
+	}
+	return inventory, result.Err()
+}
+
+// CategoryRevenue
+// The revenue of the items of a category. Items are assigned to their current category, uncategorized items and
+// deleted items have no category id.
+type CategoryRevenue struct {
+	CategoryId   string `json:"categoryId,omitempty"`
+	CategoryName string `json:"categoryName,omitempty"`
+	Sold         int    `json:"sold"`
+	Revenue      int    `json:"revenue"`
+}
+
+type RevenueReport struct {
+	Since      int64             `json:"since"`
+	Until      int64             `json:"until"`
+	Categories []CategoryRevenue `json:"categories"`
+	Revenue    int               `json:"revenue"`
+}
+
+// GetRevenueByCategory
+// Sum up the revenue of all purchases between since and until per category, net of refunds and without deposits.
+// The categories are ordered like in the item list.
+func GetRevenueByCategory(ctx context.Context, since, until int64, db *sql.DB) (RevenueReport, error) {
+	report := RevenueReport{Since: since, Until: until, Categories: make([]CategoryRevenue, 0)}
+	result, err := db.QueryContext(ctx, `SELECT c.id, c.name, SUM(t.amount), SUM(COALESCE(t.total, 0))
+		FROM transactions t LEFT JOIN items i ON i.id = t.itemId LEFT JOIN categories c ON c.id = i.category
+		WHERE t.type IN ($1, $2) AND t.timestamp > $3 AND t.timestamp < $4
+		GROUP BY c.id, c.name, c.position
+		ORDER BY c.position IS NULL, c.position, c.name`, transactions.TypePurchase, transactions.TypeRefund, since, until)
+	if err != nil {
+		return report, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var line CategoryRevenue
+		var categoryId, categoryName sql.NullString
+		err = result.Scan(&categoryId, &categoryName, &line.Sold, &line.Revenue)
+		if err != nil {
+			return report, err
+		}
+		line.CategoryId, line.CategoryName = categoryId.String, categoryName.String
+		report.Categories = append(report.Categories, line)
+		report.Revenue += line.Revenue
+	}
+	return report, result.Err()
+}
@@ -0,0 +1,79 @@
+package reports
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/stock"
+	"github.com/Port39/go-drink/testutils"
+	"github.com/Port39/go-drink/transactions"
+	"github.com/Port39/go-drink/users"
+)
+
+func TestReports(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	drinks := items.Category{Id: "00000000-0000-0000-0000-0000000000c1", Name: "Drinks", Position: 1}
+	testutils.FailOnError(items.InsertNewCategory(ctx, drinks, db), t)
+	mate := items.Item{Id: "00000000-0000-0000-0000-00000000000a", Name: "Mate", Price: 150, CategoryId: drinks.Id}
+	chips := items.Item{Id: "00000000-0000-0000-0000-00000000000b", Name: "Chips", Price: 200, Amount: 4}
+	testutils.FailOnError(items.InsertNewItem(ctx, &mate, db), t)
+	testutils.FailOnError(items.InsertNewItem(ctx, &chips, db), t)
+	buyer := users.User{Id: "00000000-0000-0000-0000-000000000001", Username: "buyer", Email: "buyer@godrink.test",
+		Role: "user", Credit: 5000}
+	testutils.FailOnError(users.AddUser(ctx, buyer, db), t)
+
+	// the cost price is the average of the stock in hand and the delivery
+	_, err := stock.Restock(ctx, buyer.Id, stock.Delivery{Lines: []stock.DeliveryLine{{ItemId: mate.Id, Amount: 10, UnitCost: 80}}}, db)
+	testutils.FailOnError(err, t)
+	_, err = stock.Restock(ctx, buyer.Id, stock.Delivery{Lines: []stock.DeliveryLine{{ItemId: mate.Id, Amount: 10, UnitCost: 100}}}, db)
+	testutils.FailOnError(err, t)
+	retrieved, err := items.GetItemById(ctx, mate.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.CostPrice, 90, t)
+
+	since := time.Now().Unix() - 1
+	receipt, err := transactions.MakeTransaction(ctx, &buyer, []transactions.CartLine{
+		{ItemId: mate.Id, Amount: 4},
+		{ItemId: chips.Id, Amount: 1},
+	}, "password", db)
+	testutils.FailOnError(err, t)
+	_, err = transactions.MakeTransaction(ctx, &buyer, []transactions.CartLine{{ItemId: mate.Id, Amount: 1}}, "password", db)
+	testutils.FailOnError(err, t)
+	_, err = transactions.RefundTransaction(ctx, receipt.Lines[0].TransactionId, "password", db)
+	testutils.FailOnError(err, t)
+	until := time.Now().Unix() + 1
+
+	margins, err := GetMargins(ctx, since, until, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(margins.Items), 2, t)
+	// chips were never restocked, so their cost is unknown
+	testutils.ExpectEqual(margins.Items[0], ItemMargin{
+		ItemId: chips.Id, ItemName: "Chips", Sold: 1, Revenue: 200, Cost: 0, Margin: 200, UnknownCost: 1,
+	}, t)
+	testutils.ExpectEqual(margins.Items[1], ItemMargin{
+		ItemId: mate.Id, ItemName: "Mate", Sold: 1, Revenue: 150, Cost: 90, Margin: 60,
+	}, t)
+	testutils.ExpectEqual(margins.Margin, 260, t)
+	margins, err = GetMargins(ctx, until, until+10, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(margins.Items), 0, t)
+
+	inventory, err := GetInventoryValue(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(inventory.Items), 2, t)
+	testutils.ExpectEqual(inventory.Items[0], ItemValue{ItemId: mate.Id, ItemName: "Mate", Amount: 19, CostPrice: 90, Value: 1710}, t)
+	testutils.ExpectEqual(inventory.Items[1].Value, 0, t)
+	testutils.ExpectEqual(inventory.Value, 1710, t)
+
+	revenue, err := GetRevenueByCategory(ctx, since, until, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(revenue.Categories), 2, t)
+	testutils.ExpectEqual(revenue.Categories[0], CategoryRevenue{CategoryId: drinks.Id, CategoryName: "Drinks", Sold: 1, Revenue: 150}, t)
+	testutils.ExpectEqual(revenue.Categories[1], CategoryRevenue{Sold: 1, Revenue: 200}, t)
+	testutils.ExpectEqual(revenue.Revenue, 350, t)
+}
@@ -3,6 +3,7 @@ package session
 import (
 	"errors"
 	"github.com/google/uuid"
+	"sync"
 	"time"
 )
 
@@ -18,10 +19,15 @@ type Store interface {
 	Get(string) (Session, error)
 	Store(Session)
 	Delete(string)
+	// DeleteForUser ends all sessions of the user, e.g. because the user has been deactivated
+	DeleteForUser(string)
 	Purge()
 }
 
+// MemoryStore
+// keeps sessions in a map guarded by a read-write lock, so it can be used by concurrent requests
 type MemoryStore struct {
+	mutex    sync.RWMutex
 	sessions map[string]Session
 }
 
@@ -44,6 +50,8 @@ func NewMemoryStore() Store {
 }
 
 func (s *MemoryStore) Get(id string) (Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	val, ok := s.sessions[id]
 	if ok {
 		return val, nil
@@ -52,17 +60,52 @@ func (s *MemoryStore) Get(id string) (Session, error) {
 }
 
 func (s *MemoryStore) Store(session Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.sessions[session.Id] = session
 }
 
 func (s *MemoryStore) Delete(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	delete(s.sessions, id)
 }
 
+func (s *MemoryStore) DeleteForUser(userId string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, sess := range s.sessions {
+		if sess.UserId == userId {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// purgeBatchSize limits how many sessions are deleted while holding the write lock
+const purgeBatchSize = 256
+
+// Purge
+// Delete all expired sessions. Expired sessions are collected while only holding the read lock, and then deleted in
+// small batches, so that concurrent requests are not blocked for the duration of the whole purge.
 func (s *MemoryStore) Purge() {
+	s.mutex.RLock()
+	expired := make([]string, 0)
 	for id, sess := range s.sessions {
 		if !IsValid(&sess) {
-			s.Delete(id)
+			expired = append(expired, id)
+		}
+	}
+	s.mutex.RUnlock()
+
+	for start := 0; start < len(expired); start += purgeBatchSize {
+		end := min(start+purgeBatchSize, len(expired))
+		s.mutex.Lock()
+		for _, id := range expired[start:end] {
+			// the session might have been replaced in the meantime
+			if sess, ok := s.sessions[id]; ok && !IsValid(&sess) {
+				delete(s.sessions, id)
+			}
 		}
+		s.mutex.Unlock()
 	}
 }
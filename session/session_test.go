@@ -0,0 +1,125 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+
+	_, err := store.Get("unknown")
+	testutils.ExpectErrorWithMessage(err, "session not found", t)
+
+	sess := CreateSession("user", "user", "password", 300)
+	store.Store(sess)
+	retrieved, err := store.Get(sess.Id)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(retrieved == sess, t)
+
+	store.Delete(sess.Id)
+	_, err = store.Get(sess.Id)
+	testutils.ExpectError(err, t)
+}
+
+// testDeleteForUser
+// Check that only the sessions of the given user are deleted
+func testDeleteForUser(t *testing.T, store Store) {
+	t.Helper()
+	first := CreateSession("user1", "user", "password", 300)
+	second := CreateSession("user1", "user", "nfc", 300)
+	other := CreateSession("user2", "user", "password", 300)
+	for _, sess := range []Session{first, second, other} {
+		store.Store(sess)
+	}
+
+	store.DeleteForUser("user1")
+
+	_, err := store.Get(first.Id)
+	testutils.ExpectError(err, t)
+	_, err = store.Get(second.Id)
+	testutils.ExpectError(err, t)
+	_, err = store.Get(other.Id)
+	testutils.FailOnError(err, t)
+}
+
+func TestMemoryStore_DeleteForUser(t *testing.T) {
+	testDeleteForUser(t, NewMemoryStore())
+}
+
+func TestMemoryStore_Purge(t *testing.T) {
+	store := NewMemoryStore()
+
+	valid := CreateSession("user1", "user", "password", 300)
+	store.Store(valid)
+	expired := make([]Session, 0)
+	for i := range 2*purgeBatchSize + 1 {
+		sess := CreateSession(fmt.Sprintf("expired%d", i), "user", "password", 300)
+		sess.NotValidAfter = time.Now().Add(-time.Minute).Unix()
+		store.Store(sess)
+		expired = append(expired, sess)
+	}
+
+	store.Purge()
+
+	for _, sess := range expired {
+		_, err := store.Get(sess.Id)
+		testutils.ExpectError(err, t)
+	}
+	_, err := store.Get(valid.Id)
+	testutils.FailOnError(err, t)
+}
+
+// TestMemoryStore_Concurrency
+// hammers the store from many goroutines at once. Run it with the race detector (go test -race) to detect
+// unsynchronized access.
+func TestMemoryStore_Concurrency(t *testing.T) {
+	store := NewMemoryStore()
+	const workers = 32
+	const iterations = 500
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				sess := CreateSession(fmt.Sprintf("user%d", w), "user", "password", 300)
+				if i%3 == 0 {
+					sess.NotValidAfter = time.Now().Add(-time.Minute).Unix()
+				}
+				store.Store(sess)
+				retrieved, err := store.Get(sess.Id)
+				// expired sessions may already have been purged by another worker
+				if err == nil && retrieved != sess {
+					t.Errorf("retrieved session %v differs from stored session %v", retrieved, sess)
+				}
+				if i%2 == 0 {
+					store.Delete(sess.Id)
+				}
+				if i%50 == 0 {
+					store.Purge()
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range iterations {
+			store.Purge()
+		}
+	}()
+	wg.Wait()
+
+	store.Purge()
+	memoryStore := store.(*MemoryStore)
+	for _, sess := range memoryStore.sessions {
+		testutils.ExpectSuccess(IsValid(&sess), t)
+	}
+}
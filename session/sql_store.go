@@ -0,0 +1,64 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// SqlStore
+// keeps sessions in the database, so that they survive restarts of the application
+type SqlStore struct {
+	db *sql.DB
+}
+
+func NewSqlStore(db *sql.DB) Store {
+	return &SqlStore{db: db}
+}
+
+func (s *SqlStore) Get(id string) (Session, error) {
+	result, err := s.db.QueryContext(context.Background(),
+		`SELECT id, user_id, role, not_valid_after, auth_backend FROM sessions WHERE id = $1`, id)
+	if err != nil {
+		return Session{}, err
+	}
+	defer result.Close()
+	if !result.Next() {
+		return Session{}, errors.New("session not found")
+	}
+	var sess Session
+	err = result.Scan(&sess.Id, &sess.UserId, &sess.Role, &sess.NotValidAfter, &sess.AuthBackend)
+	return sess, err
+}
+
+func (s *SqlStore) Store(session Session) {
+	_, err := s.db.ExecContext(context.Background(), `INSERT INTO sessions (id, user_id, role, not_valid_after, auth_backend) 
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO UPDATE SET user_id = $2, role = $3, not_valid_after = $4, auth_backend = $5`,
+		session.Id, session.UserId, session.Role, session.NotValidAfter, session.AuthBackend)
+	if err != nil {
+		log.Println("Error storing session:", err)
+	}
+}
+
+func (s *SqlStore) Delete(id string) {
+	_, err := s.db.ExecContext(context.Background(), `DELETE FROM sessions WHERE id = $1`, id)
+	if err != nil {
+		log.Println("Error deleting session:", err)
+	}
+}
+
+func (s *SqlStore) DeleteForUser(userId string) {
+	_, err := s.db.ExecContext(context.Background(), `DELETE FROM sessions WHERE user_id = $1`, userId)
+	if err != nil {
+		log.Println("Error deleting sessions of user:", err)
+	}
+}
+
+func (s *SqlStore) Purge() {
+	_, err := s.db.ExecContext(context.Background(), `DELETE FROM sessions WHERE not_valid_after <= $1`, time.Now().Unix())
+	if err != nil {
+		log.Println("Error purging expired sessions:", err)
+	}
+}
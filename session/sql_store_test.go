@@ -0,0 +1,59 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestSqlStore(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	store := NewSqlStore(db)
+
+	_, err := store.Get("unknown")
+	testutils.ExpectErrorWithMessage(err, "session not found", t)
+
+	sess := CreateSession("user", "user", "password", 300)
+	store.Store(sess)
+	retrieved, err := store.Get(sess.Id)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(retrieved == sess, t)
+
+	// storing a session a second time replaces it
+	sess.Role = "admin"
+	store.Store(sess)
+	retrieved, err = store.Get(sess.Id)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.Role, "admin", t)
+
+	store.Delete(sess.Id)
+	_, err = store.Get(sess.Id)
+	testutils.ExpectError(err, t)
+}
+
+func TestSqlStore_DeleteForUser(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	testDeleteForUser(t, NewSqlStore(db))
+}
+
+func TestSqlStore_Purge(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	store := NewSqlStore(db)
+
+	valid := CreateSession("user1", "user", "password", 300)
+	expired := CreateSession("user2", "user", "password", 300)
+	expired.NotValidAfter = time.Now().Add(-time.Minute).Unix()
+	store.Store(valid)
+	store.Store(expired)
+
+	store.Purge()
+
+	_, err := store.Get(expired.Id)
+	testutils.ExpectError(err, t)
+	_, err = store.Get(valid.Id)
+	testutils.FailOnError(err, t)
+}
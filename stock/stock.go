@@ -0,0 +1,259 @@
+package stock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/pagination"
+	"github.com/google/uuid"
+)
+
+// Types of stock movements. Sales and refunds are not stock movements, they are kept as transactions.
+const (
+	TypeRestock    = "restock"
+	TypeCorrection = "correction"
+	TypeShrinkage  = "shrinkage"
+)
+
+var ErrEmptyDelivery = errors.New("the delivery contains no items")
+
+// Movement
+// A change of the stock of an item, which has been recorded by the user. Restocks keep the purchase cost per unit and
+// the supplier, all items of the same delivery share the delivery id. Shrinkage keeps the sale price per unit, all
+// items of the same stocktake share the stocktake id.
+type Movement struct {
+	Id          string `json:"id"`
+	ItemId      string `json:"itemId"`
+	Type        string `json:"type"`
+	Amount      int    `json:"amount"`
+	UserId      string `json:"userId"`
+	UnitCost    int    `json:"unitCost,omitempty"`
+	Supplier    string `json:"supplier,omitempty"`
+	DeliveryId  string `json:"deliveryId,omitempty"`
+	UnitPrice   int    `json:"unitPrice,omitempty"`
+	StocktakeId string `json:"stocktakeId,omitempty"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// Delivery
+// Items which have been bought from a supplier
+type Delivery struct {
+	Supplier string
+	Lines    []DeliveryLine
+}
+
+type DeliveryLine struct {
+	ItemId   string
+	Amount   int
+	UnitCost int
+}
+
+const movementColumns = "id, item_id, type, amount, user_id, unit_cost, supplier, delivery_id, unit_price, stocktake_id, timestamp"
+
+func scanMovement(row interface{ Scan(dest ...any) error }) (Movement, error) {
+	var movement Movement
+	var unitCost, unitPrice sql.NullInt64
+	var supplier, deliveryId, stocktakeId sql.NullString
+	err := row.Scan(&movement.Id, &movement.ItemId, &movement.Type, &movement.Amount, &movement.UserId, &unitCost,
+		&supplier, &deliveryId, &unitPrice, &stocktakeId, &movement.Timestamp)
+	movement.UnitCost = int(unitCost.Int64)
+	movement.Supplier = supplier.String
+	movement.DeliveryId = deliveryId.String
+	movement.UnitPrice = int(unitPrice.Int64)
+	movement.StocktakeId = stocktakeId.String
+	return movement, err
+}
+
+func insertMovement(ctx context.Context, movement Movement, tx *sql.Tx) error {
+	var unitCost, unitPrice sql.NullInt64
+	if movement.Type == TypeRestock {
+		unitCost = sql.NullInt64{Int64: int64(movement.UnitCost), Valid: true}
+	}
+	if movement.Type == TypeShrinkage {
+		unitPrice = sql.NullInt64{Int64: int64(movement.UnitPrice), Valid: true}
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO stock_movements (`+movementColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		movement.Id, movement.ItemId, movement.Type, movement.Amount, movement.UserId, unitCost,
+		sql.NullString{String: movement.Supplier, Valid: movement.Supplier != ""},
+		sql.NullString{String: movement.DeliveryId, Valid: movement.DeliveryId != ""}, unitPrice,
+		sql.NullString{String: movement.StocktakeId, Valid: movement.StocktakeId != ""}, movement.Timestamp)
+	return err
+}
+
+// Restock
+// Add the delivered items to the stock, and update the cost price of the items to the weighted average of the stock
+// and the delivery. Either all items are restocked, or none of them.
+func Restock(ctx context.Context, userId string, delivery Delivery, db *sql.DB) ([]Movement, error) {
+	if len(delivery.Lines) == 0 {
+		return nil, ErrEmptyDelivery
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	movements, err := RestockWithTransaction(ctx, userId, delivery, tx)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+	return movements, tx.Commit()
+}
+
+func RestockWithTransaction(ctx context.Context, userId string, delivery Delivery, tx *sql.Tx) ([]Movement, error) {
+	movements := make([]Movement, 0, len(delivery.Lines))
+	deliveryId := uuid.New().String()
+	timestamp := time.Now().Unix()
+	for _, line := range delivery.Lines {
+		amount, err := items.ChangeStockWithTransaction(ctx, line.ItemId, line.Amount, tx)
+		if errors.Is(err, items.ErrInsufficientStock) {
+			// restocking never reduces the stock, so only unknown items can't be restocked
+			err = items.ErrNoSuchItem
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, line.ItemId)
+		}
+		// the item is locked by the update now, so its cost price can't be changed by concurrent restocks anymore
+		item, err := items.GetItemByIdWithTransaction(ctx, line.ItemId, tx)
+		if err != nil {
+			return nil, err
+		}
+		err = items.SetCostPriceWithTransaction(ctx, item.Id,
+			averageCost(amount-line.Amount, item.CostPrice, line.Amount, line.UnitCost), tx)
+		if err != nil {
+			return nil, err
+		}
+		movement := Movement{
+			Id:         uuid.New().String(),
+			ItemId:     line.ItemId,
+			Type:       TypeRestock,
+			Amount:     line.Amount,
+			UserId:     userId,
+			UnitCost:   line.UnitCost,
+			Supplier:   delivery.Supplier,
+			DeliveryId: deliveryId,
+			Timestamp:  timestamp,
+		}
+		err = insertMovement(ctx, movement, tx)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+	return movements, nil
+}
+
+// averageCost
+// The cost price of the stock after adding the restocked items, weighted by the amounts and rounded to whole cents.
+// A cost price of zero means that the cost of the stock is unknown, so only the restocked items count.
+func averageCost(amount, costPrice, restocked, unitCost int) int {
+	if costPrice == 0 {
+		amount = 0
+	}
+	amount = max(amount, 0)
+	total := amount + restocked
+	if total <= 0 {
+		return costPrice
+	}
+	return (amount*costPrice + restocked*unitCost + total/2) / total
+}
+
+// UpdateItem
+// Update the item like items.UpdateItem. If its amount is changed, the difference is recorded as a correction by the
+// user.
+func UpdateItem(ctx context.Context, item *items.Item, userId string, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = updateItemWithTransaction(ctx, item, userId, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func updateItemWithTransaction(ctx context.Context, item *items.Item, userId string, tx *sql.Tx) error {
+	previous, err := items.SetStockWithTransaction(ctx, item.Id, item.Amount, tx)
+	if err != nil {
+		return err
+	}
+	err = items.UpdateItemWithTransaction(ctx, item, tx)
+	if err != nil || item.Amount == previous {
+		return err
+	}
+	return insertMovement(ctx, Movement{
+		Id:        uuid.New().String(),
+		ItemId:    item.Id,
+		Type:      TypeCorrection,
+		Amount:    item.Amount - previous,
+		UserId:    userId,
+		Timestamp: time.Now().Unix(),
+	}, tx)
+}
+
+// Sorts
+// The keys stock movements can be sorted by, and their columns
+var Sorts = map[string]string{
+	"timestamp": "timestamp",
+}
+
+// Filter
+// Restricts which stock movements are listed. Empty fields are ignored, the time range always applies.
+type Filter struct {
+	ItemId      string
+	UserId      string
+	Type        string
+	DeliveryId  string
+	StocktakeId string
+	Since       int64
+	Until       int64
+}
+
+// GetMovements
+// Get a page of the stock movements matching the filter. If there are more movements, a cursor pointing to the next
+// page is returned as well.
+func GetMovements(ctx context.Context, filter Filter, page pagination.Page, db *sql.DB) ([]Movement, *pagination.Cursor, error) {
+	movements := make([]Movement, 0)
+	var query pagination.Query
+	query.Where("timestamp > " + query.Arg(filter.Since))
+	query.Where("timestamp < " + query.Arg(filter.Until))
+	if filter.ItemId != "" {
+		query.Where("item_id = " + query.Arg(filter.ItemId))
+	}
+	if filter.UserId != "" {
+		query.Where("user_id = " + query.Arg(filter.UserId))
+	}
+	if filter.Type != "" {
+		query.Where("type = " + query.Arg(filter.Type))
+	}
+	if filter.DeliveryId != "" {
+		query.Where("delivery_id = " + query.Arg(filter.DeliveryId))
+	}
+	if filter.StocktakeId != "" {
+		query.Where("stocktake_id = " + query.Arg(filter.StocktakeId))
+	}
+	statement, args := query.Build(`SELECT `+movementColumns+` FROM stock_movements`, "id", page)
+	result, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		movement, err := scanMovement(result)
+		if err != nil {
+			return nil, nil, err
+		}
+		movements = append(movements, movement)
+	}
+	if err = result.Err(); err != nil {
+		return nil, nil, err
+	}
+	movements, next := pagination.Trim(movements, page, func(movement Movement) (any, string) {
+		return movement.Timestamp, movement.Id
+	})
+	return movements, next, nil
+}
@@ -0,0 +1,96 @@
+package stock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/pagination"
+	"github.com/Port39/go-drink/testutils"
+)
+
+const restockerId = "00000000-0000-0000-0000-0000000000a1"
+
+func TestRestock(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	mate := items.Item{Id: "00000000-0000-0000-0000-000000000001", Name: "Mate", Price: 150, Amount: 3}
+	cola := items.Item{Id: "00000000-0000-0000-0000-000000000002", Name: "Cola", Price: 120}
+	testutils.FailOnError(items.InsertNewItem(ctx, &mate, db), t)
+	testutils.FailOnError(items.InsertNewItem(ctx, &cola, db), t)
+
+	movements, err := Restock(ctx, restockerId, Delivery{
+		Supplier: "Getränke Hoffmann",
+		Lines: []DeliveryLine{
+			{ItemId: mate.Id, Amount: 20, UnitCost: 80},
+			{ItemId: cola.Id, Amount: 24, UnitCost: 0},
+		},
+	}, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(movements), 2, t)
+	testutils.ExpectEqual(movements[0].Type, TypeRestock, t)
+	testutils.ExpectEqual(movements[0].UserId, restockerId, t)
+	testutils.ExpectEqual(movements[0].DeliveryId, movements[1].DeliveryId, t)
+	retrieved, err := items.GetItemById(ctx, mate.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.Amount, 23, t)
+	// the cost of the initial stock is unknown, so it doesn't dilute the cost price
+	testutils.ExpectEqual(retrieved.CostPrice, 80, t)
+
+	// a delivery containing an unknown item is not restocked at all
+	_, err = Restock(ctx, restockerId, Delivery{Lines: []DeliveryLine{
+		{ItemId: mate.Id, Amount: 5},
+		{ItemId: "00000000-0000-0000-0000-0000000000ff", Amount: 5},
+	}}, db)
+	testutils.ExpectSuccess(errors.Is(err, items.ErrNoSuchItem), t)
+	retrieved, err = items.GetItemById(ctx, mate.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.Amount, 23, t)
+	_, err = Restock(ctx, restockerId, Delivery{}, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrEmptyDelivery), t)
+
+	// overwriting the amount is recorded as a correction
+	retrieved.Amount = 21
+	testutils.FailOnError(UpdateItem(ctx, &retrieved, restockerId, db), t)
+	retrieved.Price = 160
+	testutils.FailOnError(UpdateItem(ctx, &retrieved, restockerId, db), t)
+
+	page, err := pagination.FromQuery(nil, Sorts, "timestamp")
+	testutils.FailOnError(err, t)
+	listed, next, err := GetMovements(ctx, Filter{ItemId: mate.Id, Until: time.Now().Unix() + 1}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(next == nil, t)
+	testutils.ExpectEqual(len(listed), 2, t)
+	// both movements may have the same timestamp, so their order is not known
+	byType := map[string]Movement{listed[0].Type: listed[0], listed[1].Type: listed[1]}
+	testutils.ExpectEqual(byType[TypeRestock], movements[0], t)
+	testutils.ExpectEqual(byType[TypeCorrection].Amount, -2, t)
+	testutils.ExpectEqual(byType[TypeCorrection].UnitCost, 0, t)
+	testutils.ExpectEqual(byType[TypeCorrection].Supplier, "", t)
+
+	page, err = pagination.FromQuery(nil, Sorts, "timestamp")
+	testutils.FailOnError(err, t)
+	listed, _, err = GetMovements(ctx, Filter{Type: TypeRestock, Until: time.Now().Unix() + 1}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(listed), 2, t)
+
+	// deleting an item which was never sold removes its stock movements as well
+	testutils.FailOnError(items.DeleteItem(ctx, cola.Id, db), t)
+	page, err = pagination.FromQuery(nil, Sorts, "timestamp")
+	testutils.FailOnError(err, t)
+	listed, _, err = GetMovements(ctx, Filter{DeliveryId: movements[1].DeliveryId, Until: time.Now().Unix() + 1}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(listed), 1, t)
+}
+
+func TestAverageCost(t *testing.T) {
+	testutils.ExpectEqual(averageCost(10, 80, 10, 100), 90, t)
+	testutils.ExpectEqual(averageCost(2, 80, 1, 81), 80, t)
+	// a negative stock or an unknown cost price are ignored
+	testutils.ExpectEqual(averageCost(-5, 80, 10, 100), 100, t)
+	testutils.ExpectEqual(averageCost(10, 0, 10, 100), 100, t)
+}
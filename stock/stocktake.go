@@ -0,0 +1,179 @@
+package stock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/google/uuid"
+)
+
+// CountedItem
+// The amount of an item which has been found while counting the stock
+type CountedItem struct {
+	ItemId string
+	Amount int
+}
+
+// Stocktake
+// The result of a stocktake. The loss is the sale price of all missing items in cents, it is negative if more items
+// have been counted than expected. Items in the assortment which have not been counted are listed as uncounted.
+type Stocktake struct {
+	Id        string          `json:"id"`
+	Timestamp int64           `json:"timestamp"`
+	Lines     []StocktakeLine `json:"lines"`
+	Loss      int             `json:"loss"`
+	Uncounted []UncountedItem `json:"uncounted"`
+}
+
+type StocktakeLine struct {
+	ItemId    string `json:"itemId"`
+	ItemName  string `json:"itemName"`
+	Expected  int    `json:"expected"`
+	Counted   int    `json:"counted"`
+	UnitPrice int    `json:"unitPrice"`
+	Loss      int    `json:"loss"`
+}
+
+// UncountedItem
+// An item which has been left out of a stocktake, with its unchanged stock
+type UncountedItem struct {
+	ItemId   string `json:"itemId"`
+	ItemName string `json:"itemName"`
+	Amount   int    `json:"amount"`
+}
+
+// TakeStock
+// Set the stock of the counted items to the counted amounts. Every discrepancy is recorded as shrinkage by the user.
+// Items which have not been counted are left unchanged, the ones which are not archived are reported as uncounted.
+func TakeStock(ctx context.Context, userId string, counted []CountedItem, db *sql.DB) (Stocktake, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Stocktake{}, err
+	}
+	stocktake, err := takeStockWithTransaction(ctx, userId, counted, tx)
+	if err != nil {
+		return Stocktake{}, errors.Join(err, tx.Rollback())
+	}
+	return stocktake, tx.Commit()
+}
+
+func takeStockWithTransaction(ctx context.Context, userId string, counted []CountedItem, tx *sql.Tx) (Stocktake, error) {
+	stocktake := Stocktake{
+		Id:        uuid.New().String(),
+		Timestamp: time.Now().Unix(),
+		Lines:     make([]StocktakeLine, 0, len(counted)),
+	}
+	for _, count := range counted {
+		item, err := items.GetItemByIdWithTransaction(ctx, count.ItemId, tx)
+		if err != nil {
+			return Stocktake{}, fmt.Errorf("%w: %s", err, count.ItemId)
+		}
+		// purchases may have changed the stock since it was read, the previous amount is the one actually replaced
+		expected, err := items.SetStockWithTransaction(ctx, item.Id, count.Amount, tx)
+		if err != nil {
+			return Stocktake{}, err
+		}
+		line := StocktakeLine{
+			ItemId:    item.Id,
+			ItemName:  item.Name,
+			Expected:  expected,
+			Counted:   count.Amount,
+			UnitPrice: item.Price,
+			Loss:      (expected - count.Amount) * item.Price,
+		}
+		stocktake.Lines = append(stocktake.Lines, line)
+		stocktake.Loss += line.Loss
+		if line.Counted == line.Expected {
+			continue
+		}
+		err = insertMovement(ctx, Movement{
+			Id:          uuid.New().String(),
+			ItemId:      item.Id,
+			Type:        TypeShrinkage,
+			Amount:      line.Counted - line.Expected,
+			UserId:      userId,
+			UnitPrice:   item.Price,
+			StocktakeId: stocktake.Id,
+			Timestamp:   stocktake.Timestamp,
+		}, tx)
+		if err != nil {
+			return Stocktake{}, err
+		}
+	}
+	uncounted, err := getUncountedItems(ctx, counted, tx)
+	if err != nil {
+		return Stocktake{}, err
+	}
+	stocktake.Uncounted = uncounted
+	return stocktake, nil
+}
+
+// getUncountedItems
+// The items which are not archived and have not been counted, ordered by their name
+func getUncountedItems(ctx context.Context, counted []CountedItem, tx *sql.Tx) ([]UncountedItem, error) {
+	countedIds := make(map[string]bool, len(counted))
+	for _, count := range counted {
+		countedIds[count.ItemId] = true
+	}
+	uncounted := make([]UncountedItem, 0)
+	result, err := tx.QueryContext(ctx, `SELECT id, name, COALESCE(amount, 0) FROM items WHERE NOT archived ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var item UncountedItem
+		err = result.Scan(&item.ItemId, &item.ItemName, &item.Amount)
+		if err != nil {
+			return nil, err
+		}
+		if !countedIds[item.ItemId] {
+			uncounted = append(uncounted, item)
+		}
+	}
+	return uncounted, result.Err()
+}
+
+// ShrinkageReport
+// The shrinkage recorded by all stocktakes in a period, per item and in total
+type ShrinkageReport struct {
+	Since int64           `json:"since"`
+	Until int64           `json:"until"`
+	Items []ShrinkageLine `json:"items"`
+	Loss  int             `json:"loss"`
+}
+
+type ShrinkageLine struct {
+	ItemId   string `json:"itemId"`
+	ItemName string `json:"itemName"`
+	Amount   int    `json:"amount"`
+	Loss     int    `json:"loss"`
+}
+
+// GetShrinkage
+// Sum up the shrinkage recorded between since and until, the items with the highest loss come first
+func GetShrinkage(ctx context.Context, since, until int64, db *sql.DB) (ShrinkageReport, error) {
+	report := ShrinkageReport{Since: since, Until: until, Items: make([]ShrinkageLine, 0)}
+	result, err := db.QueryContext(ctx, `SELECT m.item_id, i.name, SUM(m.amount), SUM(-m.amount * m.unit_price) AS loss
+		FROM stock_movements m JOIN items i ON i.id = m.item_id
+		WHERE m.type = $1 AND m.timestamp > $2 AND m.timestamp < $3
+		GROUP BY m.item_id, i.name ORDER BY loss DESC, i.name`, TypeShrinkage, since, until)
+	if err != nil {
+		return report, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var line ShrinkageLine
+		err = result.Scan(&line.ItemId, &line.ItemName, &line.Amount, &line.Loss)
+		if err != nil {
+			return report, err
+		}
+		report.Items = append(report.Items, line)
+		report.Loss += line.Loss
+	}
+	return report, result.Err()
+}
@@ -0,0 +1,84 @@
+package stock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/pagination"
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestTakeStock(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	mate := items.Item{Id: "00000000-0000-0000-0000-000000000001", Name: "Mate", Price: 150, Amount: 20}
+	cola := items.Item{Id: "00000000-0000-0000-0000-000000000002", Name: "Cola", Price: 120, Amount: 10}
+	water := items.Item{Id: "00000000-0000-0000-0000-000000000003", Name: "Water", Price: 50, Amount: 5}
+	for _, item := range []*items.Item{&mate, &cola, &water} {
+		testutils.FailOnError(items.InsertNewItem(ctx, item, db), t)
+	}
+
+	stocktake, err := TakeStock(ctx, restockerId, []CountedItem{
+		{ItemId: mate.Id, Amount: 17},
+		{ItemId: cola.Id, Amount: 11},
+		{ItemId: water.Id, Amount: 5},
+	}, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(stocktake.Lines), 3, t)
+	testutils.ExpectEqual(stocktake.Lines[0], StocktakeLine{
+		ItemId: mate.Id, ItemName: "Mate", Expected: 20, Counted: 17, UnitPrice: 150, Loss: 450,
+	}, t)
+	testutils.ExpectEqual(stocktake.Lines[1].Loss, -120, t)
+	testutils.ExpectEqual(stocktake.Loss, 330, t)
+	testutils.ExpectEqual(len(stocktake.Uncounted), 0, t)
+	retrieved, err := items.GetItemById(ctx, mate.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.Amount, 17, t)
+
+	// only discrepancies are recorded
+	page, err := pagination.FromQuery(nil, Sorts, "timestamp")
+	testutils.FailOnError(err, t)
+	movements, _, err := GetMovements(ctx, Filter{StocktakeId: stocktake.Id, Until: time.Now().Unix() + 1}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(movements), 2, t)
+	for _, movement := range movements {
+		testutils.ExpectEqual(movement.Type, TypeShrinkage, t)
+		testutils.ExpectSuccess(movement.UnitPrice == 150 || movement.UnitPrice == 120, t)
+	}
+
+	// an unknown item aborts the whole stocktake
+	_, err = TakeStock(ctx, restockerId, []CountedItem{
+		{ItemId: mate.Id, Amount: 0},
+		{ItemId: "00000000-0000-0000-0000-0000000000ff", Amount: 0},
+	}, db)
+	testutils.ExpectSuccess(errors.Is(err, items.ErrNoSuchItem), t)
+	retrieved, err = items.GetItemById(ctx, mate.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrieved.Amount, 17, t)
+
+	// the loss is reported at the price at the time of the stocktake
+	mate.Amount = 17
+	mate.Price = 200
+	testutils.FailOnError(items.UpdateItem(ctx, &mate, db), t)
+	stocktake, err = TakeStock(ctx, restockerId, []CountedItem{{ItemId: mate.Id, Amount: 16}}, db)
+	testutils.FailOnError(err, t)
+	// the items which have been left out are reported, archived ones are not expected to be counted
+	testutils.ExpectEqual(len(stocktake.Uncounted), 2, t)
+	testutils.ExpectEqual(stocktake.Uncounted[0], UncountedItem{ItemId: cola.Id, ItemName: "Cola", Amount: 11}, t)
+	testutils.ExpectEqual(stocktake.Uncounted[1].ItemId, water.Id, t)
+	testutils.FailOnError(items.SetArchived(ctx, water.Id, true, db), t)
+	stocktake, err = TakeStock(ctx, restockerId, []CountedItem{{ItemId: mate.Id, Amount: 16}}, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(stocktake.Uncounted), 1, t)
+	report, err := GetShrinkage(ctx, 0, time.Now().Unix()+1, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(report.Items), 2, t)
+	testutils.ExpectEqual(report.Items[0], ShrinkageLine{ItemId: mate.Id, ItemName: "Mate", Amount: -4, Loss: 650}, t)
+	testutils.ExpectEqual(report.Items[1], ShrinkageLine{ItemId: cola.Id, ItemName: "Cola", Amount: 1, Loss: -120}, t)
+	testutils.ExpectEqual(report.Loss, 530, t)
+}
@@ -3,7 +3,9 @@ package testutils
 import (
 	"context"
 	"database/sql"
+	"github.com/Port39/go-drink/migrations"
 	_ "modernc.org/sqlite"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,6 +16,28 @@ func GetEmptyDb(t *testing.T) *sql.DB {
 	return db
 }
 
+// GetFileDb
+// Get an empty database backed by a temporary file. In contrast to GetEmptyDb, all connections of the pool share
+// the same database, which allows testing concurrent access.
+func GetFileDb(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(10000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	FailOnError(err, t)
+	return db
+}
+
+// GetMigratedDb
+// Get an in-memory database with the full, current schema applied
+func GetMigratedDb(t *testing.T) *sql.DB {
+	t.Helper()
+	db := GetEmptyDb(t)
+	ctx, cancel := GetTestingContext(t)
+	defer cancel()
+	FailOnError(migrations.Up(ctx, db, migrations.Sqlite), t)
+	return db
+}
+
 func ExpectError(err error, t *testing.T) {
 	t.Helper()
 	ExpectSuccess(err != nil, t)
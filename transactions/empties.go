@@ -0,0 +1,106 @@
+package transactions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/users"
+	"github.com/google/uuid"
+)
+
+var ErrNoDeposit = errors.New("the item has no deposit")
+
+// ReturnEmpties
+// Credit the deposit of the returned empty bottles to the user. The credit of the cash user is not tracked, the
+// deposit is paid out in cash instead, but the return is recorded all the same.
+func ReturnEmpties(ctx context.Context, user *users.User, itemId string, bottles int, authBackend string, db *sql.DB) (Transaction, error) {
+	if bottles <= 0 {
+		return Transaction{}, ErrInvalidAmount
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transaction{}, err
+	}
+	entry, err := returnEmptiesWithTransaction(ctx, user, itemId, bottles, authBackend, tx)
+	if err != nil {
+		return Transaction{}, errors.Join(err, tx.Rollback())
+	}
+	return entry, tx.Commit()
+}
+
+func returnEmptiesWithTransaction(ctx context.Context, user *users.User, itemId string, bottles int, authBackend string, tx *sql.Tx) (Transaction, error) {
+	item, err := items.GetItemByIdWithTransaction(ctx, itemId, tx)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("%w: %s", ErrNoSuchItem, itemId)
+	}
+	if item.Deposit == 0 {
+		return Transaction{}, fmt.Errorf("%w: %s", ErrNoDeposit, item.Name)
+	}
+	entry := Transaction{
+		Id:          uuid.New().String(),
+		Type:        TypeEmptiesReturn,
+		ItemId:      item.Id,
+		ItemName:    item.Name,
+		UserId:      user.Id,
+		Amount:      bottles,
+		Money:       item.Deposit * bottles,
+		AuthBackend: authBackend,
+		Timestamp:   time.Now().Unix(),
+		UnitDeposit: item.Deposit,
+	}
+	if !user.IsCashUser() {
+		user.Credit, err = users.ChangeCreditWithTransaction(ctx, user.Id, entry.Money, tx)
+		if err != nil {
+			return Transaction{}, err
+		}
+	}
+	return entry, insertTransaction(ctx, entry, tx)
+}
+
+// DepositLiability
+// The deposits which have been charged, but not yet credited back, per item and in total. The deposit per bottle
+// may have changed over time, so the value of the bottles is summed up from the ledger.
+type DepositLiability struct {
+	Items []ItemDepositLiability `json:"items"`
+	Total int                    `json:"total"`
+}
+
+type ItemDepositLiability struct {
+	ItemId   string `json:"itemId"`
+	ItemName string `json:"itemName"`
+	Bottles  int    `json:"bottles"`
+	Total    int    `json:"total"`
+}
+
+// GetDepositLiability
+// Calculate the outstanding deposits from the purchases, refunds and returned empties. Items whose bottles have all
+// been returned are left out.
+func GetDepositLiability(ctx context.Context, db *sql.DB) (DepositLiability, error) {
+	liability := DepositLiability{Items: make([]ItemDepositLiability, 0)}
+	result, err := db.QueryContext(ctx, `SELECT t.itemId, COALESCE(i.name, MAX(t.itemName)),
+			SUM(CASE WHEN t.type = $1 THEN -t.amount ELSE t.amount END),
+			SUM(CASE WHEN t.type = $1 THEN -t.amount ELSE t.amount END * t.unitDeposit) AS total
+		FROM transactions t LEFT JOIN items i ON i.id = t.itemId
+		WHERE t.unitDeposit > 0
+		GROUP BY t.itemId, i.name
+		HAVING SUM(CASE WHEN t.type = $1 THEN -t.amount ELSE t.amount END * t.unitDeposit) <> 0
+		ORDER BY total DESC`, TypeEmptiesReturn)
+	if err != nil {
+		return liability, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var line ItemDepositLiability
+		err = result.Scan(&line.ItemId, &line.ItemName, &line.Bottles, &line.Total)
+		if err != nil {
+			return liability, err
+		}
+		liability.Items = append(liability.Items, line)
+		liability.Total += line.Total
+	}
+	return liability, result.Err()
+}
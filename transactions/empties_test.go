@@ -0,0 +1,74 @@
+package transactions
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/testutils"
+	"github.com/Port39/go-drink/users"
+)
+
+func TestReturnEmpties(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	mate := testMate
+	mate.Deposit = 8
+	testutils.FailOnError(items.UpdateItem(ctx, &mate, db), t)
+	buyer := testBuyer
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: mate.Id, Amount: 3}}, "password", db)
+	testutils.FailOnError(err, t)
+	// the deposit is charged on top of the price
+	testutils.ExpectEqual(receipt.Lines[0].Total, 450, t)
+	testutils.ExpectEqual(receipt.Lines[0].Deposit, 24, t)
+	testutils.ExpectEqual(receipt.Total, 474, t)
+	expectCredit(t, db, buyer.Id, testBuyer.Credit-474)
+
+	entry, err := ReturnEmpties(ctx, &buyer, mate.Id, 2, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(entry.Type, TypeEmptiesReturn, t)
+	testutils.ExpectEqual(entry.Money, 16, t)
+	testutils.ExpectEqual(buyer.Credit, testBuyer.Credit-458, t)
+	expectCredit(t, db, buyer.Id, testBuyer.Credit-458)
+
+	liability, err := GetDepositLiability(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(liability.Items), 1, t)
+	testutils.ExpectEqual(liability.Items[0], ItemDepositLiability{ItemId: mate.Id, ItemName: mate.Name, Bottles: 1, Total: 8}, t)
+	testutils.ExpectEqual(liability.Total, 8, t)
+
+	// refunding the purchase refunds the deposit as well
+	refund, err := RefundTransaction(ctx, receipt.Lines[0].TransactionId, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(refund.Total, -450, t)
+	testutils.ExpectEqual(refund.Money, 474, t)
+	liability, err = GetDepositLiability(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(liability.Total, -16, t)
+
+	// empties returned for cash are recorded, but the credit of the cash user is not tracked
+	cashUser, err := users.GetUserForId(ctx, users.CashUserId, db)
+	testutils.FailOnError(err, t)
+	_, err = ReturnEmpties(ctx, &cashUser, mate.Id, 2, "cash", db)
+	testutils.FailOnError(err, t)
+	liability, err = GetDepositLiability(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(liability.Total, -32, t)
+
+	_, err = ReturnEmpties(ctx, &buyer, testGranat.Id, 1, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoDeposit), t)
+	_, err = ReturnEmpties(ctx, &buyer, "00000000-0000-0000-0000-0000000000ff", 1, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+	_, err = ReturnEmpties(ctx, &buyer, mate.Id, 0, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidAmount), t)
+
+	// the initial credit of the test buyer is not part of the ledger
+	expectCredit(t, db, buyer.Id, testBuyer.Credit+16)
+	balance, err := GetLedgerBalance(ctx, buyer.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(balance, 16, t)
+}
@@ -0,0 +1,78 @@
+package transactions
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Port39/go-drink/pagination"
+)
+
+// Sorts
+// The keys transactions can be sorted by, and their columns
+var Sorts = map[string]string{
+	"timestamp": "t.timestamp",
+	"amount":    "COALESCE(t.amount, 0)",
+}
+
+// Filter
+// Restricts which transactions are listed. Empty fields are ignored, the time range always applies.
+type Filter struct {
+	UserId      string
+	ItemId      string
+	AuthBackend string
+	Type        string
+	Since       int64
+	Until       int64
+}
+
+// the item name is taken from the item if no snapshot was stored with the transaction
+const historyColumns = `t.id, t.type, t.itemId, COALESCE(t.itemName, i.name), t.userId, COALESCE(t.amount, 0), t.unitPrice, t.total,
+	t.money, t.authBackend, t.timestamp, t.reverses, t.counterpart, t.note, t.unitDeposit,
+	t.unitCost, t.pricingRule`
+
+// GetTransactions
+// Get a page of the transactions matching the filter. If there are more transactions, a cursor pointing to the next
+// page is returned as well.
+func GetTransactions(ctx context.Context, filter Filter, page pagination.Page, db *sql.DB) ([]Transaction, *pagination.Cursor, error) {
+	transactions := make([]Transaction, 0)
+	var query pagination.Query
+	query.Where("t.timestamp > " + query.Arg(filter.Since))
+	query.Where("t.timestamp < " + query.Arg(filter.Until))
+	if filter.UserId != "" {
+		query.Where("t.userId = " + query.Arg(filter.UserId))
+	}
+	if filter.ItemId != "" {
+		query.Where("t.itemId = " + query.Arg(filter.ItemId))
+	}
+	if filter.AuthBackend != "" {
+		query.Where("t.authBackend = " + query.Arg(filter.AuthBackend))
+	}
+	if filter.Type != "" {
+		query.Where("t.type = " + query.Arg(filter.Type))
+	}
+	statement, args := query.Build(`SELECT `+historyColumns+` FROM transactions t LEFT JOIN items i ON i.id = t.itemId`,
+		"t.id", page)
+
+	result, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return transactions, nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		tr, err := scanTransaction(result)
+		if err != nil {
+			return transactions, nil, err
+		}
+		transactions = append(transactions, tr)
+	}
+	if err = result.Err(); err != nil {
+		return transactions, nil, err
+	}
+	transactions, next := pagination.Trim(transactions, page, func(tr Transaction) (any, string) {
+		if page.Sort == "amount" {
+			return tr.Amount, tr.Id
+		}
+		return tr.Timestamp, tr.Id
+	})
+	return transactions, next, nil
+}
@@ -0,0 +1,70 @@
+package transactions
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/pagination"
+	"github.com/Port39/go-drink/testutils"
+	"github.com/Port39/go-drink/users"
+)
+
+func TestGetTransactions(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	// most purchases share the same timestamp, so the pages must be ordered by id as well
+	buyer := testBuyer
+	for range 5 {
+		_, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 1}}, "password", db)
+		testutils.FailOnError(err, t)
+	}
+	cashUser, err := users.GetUserForId(ctx, users.CashUserId, db)
+	testutils.FailOnError(err, t)
+	_, err = MakeTransaction(ctx, &cashUser, []CartLine{{ItemId: testGranat.Id, Amount: 1}}, "cash", db)
+	testutils.FailOnError(err, t)
+
+	filter := Filter{UserId: testBuyer.Id, Until: time.Now().Unix() + 1}
+	seen := make(map[string]bool)
+	query := url.Values{"limit": {"2"}}
+	pages := 0
+	for {
+		page, err := pagination.FromQuery(query, Sorts, "-timestamp")
+		testutils.FailOnError(err, t)
+		list, next, err := GetTransactions(ctx, filter, page, db)
+		testutils.FailOnError(err, t)
+		pages++
+		for _, tr := range list {
+			testutils.ExpectEqual(tr.UserId, testBuyer.Id, t)
+			testutils.ExpectEqual(tr.ItemName, testMate.Name, t)
+			testutils.ExpectFailure(seen[tr.Id], t)
+			seen[tr.Id] = true
+		}
+		if next == nil {
+			break
+		}
+		query.Set("cursor", next.String())
+	}
+	testutils.ExpectEqual(len(seen), 5, t)
+	testutils.ExpectEqual(pages, 3, t)
+
+	page, err := pagination.FromQuery(url.Values{}, Sorts, "-timestamp")
+	testutils.FailOnError(err, t)
+	list, next, err := GetTransactions(ctx, Filter{AuthBackend: "cash", Type: TypePurchase, Until: filter.Until}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(list), 1, t)
+	testutils.ExpectEqual(list[0].ItemId, testGranat.Id, t)
+	testutils.ExpectSuccess(next == nil, t)
+
+	list, _, err = GetTransactions(ctx, Filter{ItemId: testMate.Id, Type: TypeRefund, Until: filter.Until}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(list), 0, t)
+
+	list, _, err = GetTransactions(ctx, Filter{Since: filter.Until, Until: filter.Until + 10}, page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(list), 0, t)
+}
@@ -0,0 +1,139 @@
+package transactions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Port39/go-drink/users"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidAmount    = errors.New("the amount must not be zero")
+	ErrSelfTransfer     = errors.New("credit can't be transferred to yourself")
+	ErrCashUserTransfer = errors.New("credit can't be transferred from or to the cash user")
+)
+
+// ChangeCredit
+// Add diff (which may be negative) to the credit of the user, and record the change in the ledger. Counterpart and note
+// are optional, e.g. the admin making a correction and their reason.
+func ChangeCredit(ctx context.Context, userId string, diff int, entryType, authBackend, counterpart, note string, db *sql.DB) (Transaction, error) {
+	if diff == 0 {
+		return Transaction{}, ErrInvalidAmount
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transaction{}, err
+	}
+	entry := Transaction{
+		Id:          uuid.New().String(),
+		Type:        entryType,
+		UserId:      userId,
+		Money:       diff,
+		AuthBackend: authBackend,
+		Timestamp:   time.Now().Unix(),
+		Counterpart: counterpart,
+		Note:        note,
+	}
+	err = bookWithTransaction(ctx, entry, tx)
+	if err != nil {
+		return Transaction{}, errors.Join(err, tx.Rollback())
+	}
+	return entry, tx.Commit()
+}
+
+// Transfer
+// Move credit from one user to another. Both sides of the transfer are recorded in the ledger, and the entry of the
+// sender is returned.
+func Transfer(ctx context.Context, fromId, toId string, amount int, authBackend string, db *sql.DB) (Transaction, error) {
+	if amount <= 0 {
+		return Transaction{}, ErrInvalidAmount
+	}
+	if fromId == toId {
+		return Transaction{}, ErrSelfTransfer
+	}
+	if fromId == users.CashUserId || toId == users.CashUserId {
+		return Transaction{}, ErrCashUserTransfer
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transaction{}, err
+	}
+	timestamp := time.Now().Unix()
+	sent := Transaction{
+		Id:          uuid.New().String(),
+		Type:        TypeTransfer,
+		UserId:      fromId,
+		Money:       -amount,
+		AuthBackend: authBackend,
+		Timestamp:   timestamp,
+		Counterpart: toId,
+	}
+	received := Transaction{
+		Id:          uuid.New().String(),
+		Type:        TypeTransfer,
+		UserId:      toId,
+		Money:       amount,
+		AuthBackend: authBackend,
+		Timestamp:   timestamp,
+		Counterpart: fromId,
+	}
+	err = bookWithTransaction(ctx, sent, tx)
+	if err == nil {
+		err = bookWithTransaction(ctx, received, tx)
+	}
+	if err != nil {
+		return Transaction{}, errors.Join(err, tx.Rollback())
+	}
+	return sent, tx.Commit()
+}
+
+func bookWithTransaction(ctx context.Context, entry Transaction, tx *sql.Tx) error {
+	_, err := users.ChangeCreditWithTransaction(ctx, entry.UserId, entry.Money, tx)
+	if err != nil {
+		return err
+	}
+	return insertTransaction(ctx, entry, tx)
+}
+
+// GetLedgerBalance
+// Calculate the credit of a user from the ledger
+func GetLedgerBalance(ctx context.Context, userId string, db *sql.DB) (int, error) {
+	var balance int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(SUM(money), 0) FROM transactions WHERE userId = $1`, userId).Scan(&balance)
+	return balance, err
+}
+
+type LedgerMismatch struct {
+	UserId        string `json:"userId"`
+	Username      string `json:"username"`
+	Credit        int    `json:"credit"`
+	LedgerBalance int    `json:"ledgerBalance"`
+}
+
+// CheckLedger
+// Find all users whose credit differs from the balance derived from the ledger. The credit of the cash user is not
+// tracked, and therefore never checked.
+func CheckLedger(ctx context.Context, db *sql.DB) ([]LedgerMismatch, error) {
+	mismatches := make([]LedgerMismatch, 0)
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, COALESCE(u.credit, 0), COALESCE(SUM(t.money), 0)
+		FROM users u LEFT JOIN transactions t ON t.userId = u.id
+		WHERE u.id <> $1
+		GROUP BY u.id, u.username, u.credit
+		HAVING COALESCE(u.credit, 0) <> COALESCE(SUM(t.money), 0)`, users.CashUserId)
+	if err != nil {
+		return mismatches, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var mismatch LedgerMismatch
+		err = result.Scan(&mismatch.UserId, &mismatch.Username, &mismatch.Credit, &mismatch.LedgerBalance)
+		if err != nil {
+			return mismatches, err
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches, result.Err()
+}
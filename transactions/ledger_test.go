@@ -0,0 +1,100 @@
+package transactions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/testutils"
+	"github.com/Port39/go-drink/users"
+)
+
+func TestLedger(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	alice := users.User{Id: "00000000-0000-0000-0000-0000000000a1", Username: "alice", Role: "user"}
+	bob := users.User{Id: "00000000-0000-0000-0000-0000000000b0", Username: "bob", Role: "user"}
+	testutils.FailOnError(users.AddUser(ctx, alice, db), t)
+	testutils.FailOnError(users.AddUser(ctx, bob, db), t)
+
+	deposit, err := ChangeCredit(ctx, alice.Id, 1000, TypeDeposit, "password", "", "", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(deposit.Money, 1000, t)
+
+	_, err = ChangeCredit(ctx, alice.Id, -2000, TypeWithdrawal, "password", "", "", db)
+	testutils.ExpectSuccess(errors.Is(err, users.ErrInsufficientCredit), t)
+	_, err = ChangeCredit(ctx, alice.Id, 0, TypeDeposit, "password", "", "", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidAmount), t)
+
+	_, err = Transfer(ctx, alice.Id, bob.Id, 300, "password", db)
+	testutils.FailOnError(err, t)
+	_, err = Transfer(ctx, bob.Id, alice.Id, 301, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, users.ErrInsufficientCredit), t)
+	_, err = Transfer(ctx, alice.Id, alice.Id, 1, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrSelfTransfer), t)
+	_, err = Transfer(ctx, alice.Id, users.CashUserId, 1, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrCashUserTransfer), t)
+
+	buyer := alice
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 2}}, "password", db)
+	testutils.FailOnError(err, t)
+	_, err = UndoTransaction(ctx, receipt.Lines[0].TransactionId, alice.Id, time.Minute, "password", db)
+	testutils.FailOnError(err, t)
+	_, err = MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 1}}, "password", db)
+	testutils.FailOnError(err, t)
+
+	_, err = ChangeCredit(ctx, bob.Id, -50, TypeCorrection, "password", users.AdminUserId, "miscounted", db)
+	testutils.FailOnError(err, t)
+
+	expectCredit(t, db, alice.Id, 1000-300-150)
+	expectCredit(t, db, bob.Id, 300-50)
+	for _, user := range []users.User{alice, bob} {
+		retrieved, err := users.GetUserForId(ctx, user.Id, db)
+		testutils.FailOnError(err, t)
+		balance, err := GetLedgerBalance(ctx, user.Id, db)
+		testutils.FailOnError(err, t)
+		testutils.ExpectEqual(balance, retrieved.Credit, t)
+	}
+
+	// the test buyer was created with a credit, but without a ledger entry
+	mismatches, err := CheckLedger(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(mismatches), 1, t)
+	testutils.ExpectEqual(mismatches[0].UserId, testBuyer.Id, t)
+	testutils.ExpectEqual(mismatches[0].Credit, testBuyer.Credit, t)
+	testutils.ExpectEqual(mismatches[0].LedgerBalance, 0, t)
+
+	entries, err := GetTransactionsSince(ctx, 0, time.Now().Unix()+1, db)
+	testutils.FailOnError(err, t)
+	types := make(map[string]int)
+	for _, entry := range entries {
+		types[entry.Type]++
+	}
+	testutils.ExpectEqual(types[TypeDeposit], 1, t)
+	testutils.ExpectEqual(types[TypeTransfer], 2, t)
+	testutils.ExpectEqual(types[TypePurchase], 2, t)
+	testutils.ExpectEqual(types[TypeRefund], 1, t)
+	testutils.ExpectEqual(types[TypeCorrection], 1, t)
+}
+
+func TestRefundLegacyPurchase(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	// purchases recorded before the ledger have no money amount
+	_, err := db.ExecContext(ctx, `INSERT INTO transactions (id, itemId, userId, amount, authBackend, timestamp) 
+		VALUES ('00000000-0000-0000-0000-00000000001e', $1, $2, 2, 'password', 0)`, testMate.Id, testBuyer.Id)
+	testutils.FailOnError(err, t)
+
+	reversal, err := RefundTransaction(ctx, "00000000-0000-0000-0000-00000000001e", "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(reversal.Money, 2*testMate.Price, t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit+2*testMate.Price)
+}
@@ -0,0 +1,133 @@
+package transactions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/users"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	ErrNoSuchTransaction  = errors.New("no such transaction")
+	ErrAlreadyReversed    = errors.New("the transaction has already been reversed")
+	ErrNotReversible      = errors.New("only purchases can be reversed")
+	ErrUndoWindowExceeded = errors.New("the transaction is too old to be undone")
+)
+
+// UndoTransaction
+// Reverse a purchase on behalf of the buyer. This is only possible within the given time window after the purchase.
+func UndoTransaction(ctx context.Context, id, userId string, window time.Duration, authBackend string, db *sql.DB) (Transaction, error) {
+	return reverseTransaction(ctx, id, authBackend, db, func(original Transaction) error {
+		// transactions of other users are treated as unknown, so their existence is not revealed
+		if original.UserId != userId {
+			return ErrNoSuchTransaction
+		}
+		if time.Since(time.Unix(original.Timestamp, 0)) > window {
+			return ErrUndoWindowExceeded
+		}
+		return nil
+	})
+}
+
+// RefundTransaction
+// Reverse a purchase regardless of its age, e.g. by an admin
+func RefundTransaction(ctx context.Context, id, authBackend string, db *sql.DB) (Transaction, error) {
+	return reverseTransaction(ctx, id, authBackend, db, func(original Transaction) error {
+		return nil
+	})
+}
+
+// reverseTransaction
+// Restore the stock and credit of a purchase, and record a compensating transaction referencing the original one.
+// The original transaction is kept, so the history stays complete.
+func reverseTransaction(ctx context.Context, id, authBackend string, db *sql.DB, check func(original Transaction) error) (Transaction, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transaction{}, err
+	}
+	reversal, err := reverseTransactionWithTransaction(ctx, id, authBackend, tx, check)
+	if err != nil {
+		return Transaction{}, errors.Join(err, tx.Rollback())
+	}
+	return reversal, tx.Commit()
+}
+
+func reverseTransactionWithTransaction(ctx context.Context, id, authBackend string, tx *sql.Tx, check func(original Transaction) error) (Transaction, error) {
+	original, err := getTransactionByIdWithTransaction(ctx, id, tx)
+	if err != nil {
+		return Transaction{}, err
+	}
+	err = check(original)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if original.Type != TypePurchase {
+		return Transaction{}, ErrNotReversible
+	}
+	_, err = items.ChangeStockWithTransaction(ctx, original.ItemId, original.Amount, tx)
+	if err != nil {
+		return Transaction{}, err
+	}
+	// the deposit is refunded together with the price
+	money, total, unitPrice, itemName := -original.Money, -original.Total, original.UnitPrice, original.ItemName
+	if original.legacy {
+		// the price paid is not known for purchases recorded before the ledger, so the current price is refunded
+		item, err := items.GetItemByIdWithTransaction(ctx, original.ItemId, tx)
+		if err != nil {
+			return Transaction{}, err
+		}
+		money, unitPrice, itemName = item.Price*original.Amount, item.Price, item.Name
+		total = -money
+	}
+	if original.UserId != users.CashUserId {
+		_, err = users.ChangeCreditWithTransaction(ctx, original.UserId, money, tx)
+		if err != nil {
+			return Transaction{}, err
+		}
+	}
+
+	reversal := Transaction{
+		Id:            uuid.New().String(),
+		Type:          TypeRefund,
+		ItemId:        original.ItemId,
+		ItemName:      itemName,
+		UserId:        original.UserId,
+		Amount:        -original.Amount,
+		UnitPrice:     unitPrice,
+		Total:         total,
+		Money:         money,
+		AuthBackend:   authBackend,
+		Timestamp:     time.Now().Unix(),
+		Reverses:      original.Id,
+		UnitDeposit:   original.UnitDeposit,
+		UnitCost:      original.UnitCost,
+		PricingRuleId: original.PricingRuleId,
+	}
+	err = insertTransaction(ctx, reversal, tx)
+	if isUniqueViolation(err) {
+		// the unique index on reverses also catches concurrent reversals, which a check beforehand would let through
+		return Transaction{}, ErrAlreadyReversed
+	}
+	return reversal, err
+}
+
+// isUniqueViolation
+// Whether the error was caused by a unique index, e.g. the one allowing each transaction to be reversed only once
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package transactions
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/migrations"
+	"github.com/Port39/go-drink/testutils"
+	"github.com/Port39/go-drink/users"
+)
+
+func TestUndoTransaction(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	buyer := testBuyer
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 2}}, "password", db)
+	testutils.FailOnError(err, t)
+	purchaseId := receipt.Lines[0].TransactionId
+
+	// other users can't undo the purchase
+	_, err = UndoTransaction(ctx, purchaseId, users.CashUserId, time.Minute, "cash", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchTransaction), t)
+
+	// neither can the buyer after the undo window
+	_, err = UndoTransaction(ctx, purchaseId, testBuyer.Id, -time.Second, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrUndoWindowExceeded), t)
+
+	reversal, err := UndoTransaction(ctx, purchaseId, testBuyer.Id, time.Minute, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(reversal.Reverses, purchaseId, t)
+	testutils.ExpectEqual(reversal.Amount, -2, t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit)
+	expectStock(t, db, testMate, testMate.Amount)
+
+	// the original purchase is kept
+	original, err := GetTransactionById(ctx, purchaseId, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(original.Amount, 2, t)
+
+	// a purchase can only be undone once, and reversals can't be undone
+	_, err = UndoTransaction(ctx, purchaseId, testBuyer.Id, time.Minute, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrAlreadyReversed), t)
+	_, err = UndoTransaction(ctx, reversal.Id, testBuyer.Id, time.Minute, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNotReversible), t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit)
+	expectStock(t, db, testMate, testMate.Amount)
+}
+
+func TestRefundTransaction(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	_, err := RefundTransaction(ctx, "00000000-0000-0000-0000-0000000000ff", "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchTransaction), t)
+
+	buyer := testBuyer
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testGranat.Id, Amount: 1}}, "password", db)
+	testutils.FailOnError(err, t)
+	_, err = db.ExecContext(ctx, `UPDATE transactions SET timestamp = $1 WHERE id = $2`,
+		time.Now().Add(-24*time.Hour).Unix(), receipt.Lines[0].TransactionId)
+	testutils.FailOnError(err, t)
+
+	// refunds are possible regardless of the age of the purchase
+	reversal, err := RefundTransaction(ctx, receipt.Lines[0].TransactionId, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(reversal.UserId, testBuyer.Id, t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit)
+	expectStock(t, db, testGranat, testGranat.Amount)
+
+	_, err = RefundTransaction(ctx, receipt.Lines[0].TransactionId, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrAlreadyReversed), t)
+}
+
+// TestRefundTransaction_Concurrency
+// refunds the same purchase many times in parallel, and verifies that it is reversed exactly once
+func TestRefundTransaction_Concurrency(t *testing.T) {
+	db := testutils.GetFileDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	testutils.FailOnError(migrations.Up(ctx, db, migrations.Sqlite), t)
+	setupShop(t, db)
+
+	buyer := testBuyer
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 2}}, "password", db)
+	testutils.FailOnError(err, t)
+
+	const refunds = 50
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	refunded := 0
+	for range refunds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := RefundTransaction(ctx, receipt.Lines[0].TransactionId, "password", db)
+			if err != nil && !errors.Is(err, ErrAlreadyReversed) {
+				t.Error(err)
+				return
+			}
+			if err == nil {
+				mutex.Lock()
+				refunded++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	testutils.ExpectEqual(refunded, 1, t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit)
+	expectStock(t, db, testMate, testMate.Amount)
+}
@@ -4,44 +4,114 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/Port39/go-drink/events"
 	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/pricing"
 	"github.com/Port39/go-drink/users"
 	"github.com/google/uuid"
 	"log"
 	"time"
 )
 
+const (
+	TypePurchase   = "purchase"
+	TypeRefund     = "refund"
+	TypeDeposit    = "deposit"
+	TypeWithdrawal = "withdrawal"
+	TypeTransfer   = "transfer"
+	TypeCorrection = "correction"
+	// TypeEmptiesReturn credits the deposit of returned empty bottles, not to be confused with a deposit of credit
+	TypeEmptiesReturn = "empties-return"
+)
+
+// Transaction
+// An entry of the ledger. Money is the signed change of the user's credit caused by the transaction, while amount is
+// the number of items bought (or returned, if negative). Counterpart is the other user involved in a transfer, or the
+// admin who made a correction. Purchases and refunds keep a snapshot of the item's name and unit price, as well as the
+// total paid, so they are not affected by later changes to the item. The bottle deposit is not part of the total, but
+// of the money, and kept per unit for purchases, refunds and returned empties. PricingRuleId is the pricing rule the
+// purchase has been charged with, if any.
 type Transaction struct {
-	Id          string `json:"id"`
-	ItemId      string `json:"itemId"`
-	UserId      string `json:"userId"`
-	Amount      int    `json:"amount"`
-	AuthBackend string `json:"authBackend"`
-	Timestamp   int64  `json:"timestamp"`
+	Id            string `json:"id"`
+	Type          string `json:"type"`
+	ItemId        string `json:"itemId,omitempty"`
+	ItemName      string `json:"itemName,omitempty"`
+	UserId        string `json:"userId"`
+	Amount        int    `json:"amount"`
+	UnitPrice     int    `json:"unitPrice,omitempty"`
+	Total         int    `json:"total,omitempty"`
+	Money         int    `json:"money"`
+	AuthBackend   string `json:"authBackend"`
+	Timestamp     int64  `json:"timestamp"`
+	Reverses      string `json:"reverses,omitempty"`
+	Counterpart   string `json:"counterpart,omitempty"`
+	Note          string `json:"note,omitempty"`
+	UnitDeposit   int    `json:"unitDeposit,omitempty"`
+	PricingRuleId string `json:"pricingRuleId,omitempty"`
+	// UnitCost is the cost price of the item at the time of the purchase, it is only shown in the reports
+	UnitCost int `json:"-"`
+	// legacy is set for purchases recorded before the money amount was tracked
+	legacy bool
+}
+
+const transactionColumns = "id, type, itemid, itemname, userid, amount, unitprice, total, money, authbackend, timestamp, reverses, counterpart, note, unitdeposit, unitcost, pricingrule"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTransaction(row scanner) (Transaction, error) {
+	var tr Transaction
+	var itemId, itemName, reverses, counterpart, note, pricingRule sql.NullString
+	var unitPrice, total, money, unitDeposit, unitCost sql.NullInt64
+	err := row.Scan(&tr.Id, &tr.Type, &itemId, &itemName, &tr.UserId, &tr.Amount, &unitPrice, &total, &money,
+		&tr.AuthBackend, &tr.Timestamp, &reverses, &counterpart, &note, &unitDeposit, &unitCost,
+		&pricingRule)
+	tr.ItemId = itemId.String
+	tr.ItemName = itemName.String
+	tr.UnitPrice = int(unitPrice.Int64)
+	tr.Total = int(total.Int64)
+	tr.Money = int(money.Int64)
+	tr.legacy = !money.Valid
+	tr.Reverses = reverses.String
+	tr.Counterpart = counterpart.String
+	tr.Note = note.String
+	tr.UnitDeposit = int(unitDeposit.Int64)
+	tr.UnitCost = int(unitCost.Int64)
+	tr.PricingRuleId = pricingRule.String
+	return tr, err
 }
 
-func VerifyTransactionTableExists(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS transactions (
-    		id VARCHAR (36) PRIMARY KEY,
-    		itemId VARCHAR (36),
-    		userId VARCHAR (36),
-    		amount INTEGER,
-    		authBackend VARCHAR (16),
-    		timestamp INTEGER
-		)`)
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func insertTransaction(ctx context.Context, tr Transaction, tx *sql.Tx) error {
+	var unitPrice, total, unitDeposit, unitCost any
+	if tr.ItemId != "" {
+		unitPrice, total, unitDeposit, unitCost = tr.UnitPrice, tr.Total, tr.UnitDeposit, tr.UnitCost
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO transactions (id, type, itemId, itemName, userId, amount, unitPrice, total, money, authBackend, timestamp, reverses, counterpart, note, unitDeposit, unitCost, pricingRule) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`, tr.Id, tr.Type, nullIfEmpty(tr.ItemId),
+		nullIfEmpty(tr.ItemName), tr.UserId, tr.Amount, unitPrice, total, tr.Money, tr.AuthBackend, tr.Timestamp,
+		nullIfEmpty(tr.Reverses), nullIfEmpty(tr.Counterpart), nullIfEmpty(tr.Note), unitDeposit, unitCost,
+		nullIfEmpty(tr.PricingRuleId))
 	return err
 }
 
 func GetTransactionsSince(ctx context.Context, since, until int64, db *sql.DB) ([]Transaction, error) {
 	transactions := make([]Transaction, 0)
-	result, err := db.QueryContext(ctx, "SELECT id, itemid, userid, amount, authbackend, timestamp FROM transactions WHERE timestamp > $1 AND timestamp < $2", since, until)
+	result, err := db.QueryContext(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE timestamp > $1 AND timestamp < $2", since, until)
 	if err != nil {
 		return transactions, err
 	}
 	defer result.Close()
 	for result.Next() {
-		var tr Transaction
-		err = result.Scan(&tr.Id, &tr.ItemId, &tr.UserId, &tr.Amount, &tr.AuthBackend, &tr.Timestamp)
+		tr, err := scanTransaction(result)
 		if err != nil {
 			log.Println("Error reading results:", err)
 		}
@@ -50,46 +120,186 @@ func GetTransactionsSince(ctx context.Context, since, until int64, db *sql.DB) (
 	return transactions, nil
 }
 
-func MakeTransaction(ctx context.Context, user *users.User, item *items.Item, amount int, authBackend string, db *sql.DB) error {
-	finalPrice := item.Price * amount
-	if user.Credit < finalPrice {
-		return errors.New("not enough credits")
+func GetTransactionById(ctx context.Context, id string, db *sql.DB) (Transaction, error) {
+	tr, err := scanTransaction(db.QueryRowContext(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Transaction{}, ErrNoSuchTransaction
+	}
+	return tr, err
+}
+
+func getTransactionByIdWithTransaction(ctx context.Context, id string, tx *sql.Tx) (Transaction, error) {
+	tr, err := scanTransaction(tx.QueryRowContext(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Transaction{}, ErrNoSuchTransaction
+	}
+	return tr, err
+}
+
+var (
+	ErrEmptyCart        = errors.New("the cart is empty")
+	ErrNoSuchItem       = errors.New("no such item")
+	ErrItemArchived     = errors.New("the item is no longer sold")
+	ErrNotEnoughCredits = errors.New("not enough credits")
+	ErrNotEnoughStock   = errors.New("not enough items in stock")
+)
+
+// CartLine
+// One line of a purchase, i.e. an item and how many of it should be bought
+type CartLine struct {
+	ItemId string
+	Amount int
+}
+
+type ReceiptLine struct {
+	TransactionId string `json:"transactionId"`
+	ItemId        string `json:"itemId"`
+	Name          string `json:"name"`
+	Amount        int    `json:"amount"`
+	UnitPrice     int    `json:"unitPrice"`
+	Total         int    `json:"total"`
+	Deposit       int    `json:"deposit,omitempty"`
+	// PricingRule is the name of the pricing rule the line has been charged with
+	PricingRule string `json:"pricingRule,omitempty"`
+}
+
+// Receipt
+// The total includes the deposits of all lines, i.e. it is what has been charged
+type Receipt struct {
+	Lines   []ReceiptLine `json:"lines"`
+	Total   int           `json:"total"`
+	Balance int           `json:"balance"`
+	// lowStock are the items which fell below their minimum stock, they are published once the purchase is committed
+	lowStock []events.LowStock
+}
+
+// mergeCartLines
+// Combine lines referring to the same item, while keeping the order in which the items appeared first
+func mergeCartLines(lines []CartLine) []CartLine {
+	merged := make([]CartLine, 0, len(lines))
+	indices := make(map[string]int)
+	for _, line := range lines {
+		if i, ok := indices[line.ItemId]; ok {
+			merged[i].Amount += line.Amount
+			continue
+		}
+		indices[line.ItemId] = len(merged)
+		merged = append(merged, line)
 	}
-	if item.Amount < amount {
-		return errors.New("not enough items in stock")
+	return merged
+}
+
+// MakeTransaction
+// Buy all lines of the cart for the given user. Either all lines are bought, or none of them, if the credit of the
+// user or the stock of any item is insufficient. The lines are charged according to the pricing rules valid at the
+// time of the purchase.
+func MakeTransaction(ctx context.Context, user *users.User, lines []CartLine, authBackend string, db *sql.DB) (Receipt, error) {
+	lines = mergeCartLines(lines)
+	if len(lines) == 0 {
+		return Receipt{}, ErrEmptyCart
 	}
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
-	if !user.IsCashUser() {
-		user.Credit = user.Credit - finalPrice
-		err = users.UpdateUserWithTransaction(ctx, user, tx)
-		if err != nil {
-			err := tx.Rollback()
-			if err != nil {
-				return err
-			}
-			return err
-		}
+	receipt, err := makeTransaction(ctx, user, lines, authBackend, tx)
+	if err != nil {
+		return Receipt{}, errors.Join(err, tx.Rollback())
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Receipt{}, err
+	}
+	for _, lowStock := range receipt.lowStock {
+		events.Publish(events.TypeLowStock, lowStock)
+	}
+	return receipt, nil
+}
+
+func makeTransaction(ctx context.Context, user *users.User, lines []CartLine, authBackend string, tx *sql.Tx) (Receipt, error) {
+	buyer, err := users.GetUserForIdWithTransaction(ctx, user.Id, tx)
+	if err != nil {
+		return Receipt{}, err
 	}
-	item.Amount = item.Amount - amount
-	err = items.UpdateItemWithTransaction(ctx, item, tx)
+	receipt := Receipt{Lines: make([]ReceiptLine, 0, len(lines))}
+	now := time.Now()
+	timestamp := now.Unix()
+	rules, err := pricing.GetRulesAtWithTransaction(ctx, now, tx)
 	if err != nil {
-		err := tx.Rollback()
+		return Receipt{}, err
+	}
+	for _, line := range lines {
+		item, err := items.GetItemByIdWithTransaction(ctx, line.ItemId, tx)
+		if err != nil {
+			return Receipt{}, fmt.Errorf("%w: %s", ErrNoSuchItem, line.ItemId)
+		}
+		if item.Archived {
+			return Receipt{}, fmt.Errorf("%w: %s", ErrItemArchived, item.Name)
+		}
+		item.Amount, err = items.ChangeStockWithTransaction(ctx, item.Id, -line.Amount, tx)
+		if errors.Is(err, items.ErrInsufficientStock) {
+			return Receipt{}, fmt.Errorf("%w: %s", ErrNotEnoughStock, item.Name)
+		}
+		if err != nil {
+			return Receipt{}, err
+		}
+		// only the purchase crossing the threshold notifies, not every following one. The amount before the purchase
+		// is derived from the decrement, as concurrent purchases may have changed the stock since it has been read.
+		wasLowOnStock := item.Amount+line.Amount < item.MinStock
+		if !wasLowOnStock && item.IsLowOnStock() {
+			receipt.lowStock = append(receipt.lowStock, events.LowStock{
+				ItemId:   item.Id,
+				ItemName: item.Name,
+				Amount:   item.Amount,
+				MinStock: item.MinStock,
+			})
+		}
+		quote := pricing.Price(rules, &item, line.Amount, &buyer, now)
+		receiptLine := ReceiptLine{
+			TransactionId: uuid.New().String(),
+			ItemId:        item.Id,
+			Name:          item.Name,
+			Amount:        line.Amount,
+			UnitPrice:     quote.UnitPrice,
+			Total:         quote.Total,
+			Deposit:       item.Deposit * line.Amount,
+		}
+		var pricingRuleId string
+		if quote.Rule != nil {
+			pricingRuleId, receiptLine.PricingRule = quote.Rule.Id, quote.Rule.Name
+		}
+		err = insertTransaction(ctx, Transaction{
+			Id:            receiptLine.TransactionId,
+			Type:          TypePurchase,
+			ItemId:        item.Id,
+			ItemName:      item.Name,
+			UserId:        buyer.Id,
+			Amount:        line.Amount,
+			UnitPrice:     receiptLine.UnitPrice,
+			Total:         receiptLine.Total,
+			Money:         -receiptLine.Total - receiptLine.Deposit,
+			AuthBackend:   authBackend,
+			Timestamp:     timestamp,
+			UnitDeposit:   item.Deposit,
+			UnitCost:      item.CostPrice,
+			PricingRuleId: pricingRuleId,
+		}, tx)
 		if err != nil {
-			return err
+			return Receipt{}, err
 		}
-		return err
+		receipt.Lines = append(receipt.Lines, receiptLine)
+		receipt.Total += receiptLine.Total + receiptLine.Deposit
 	}
-	_, err = tx.ExecContext(ctx, `INSERT INTO transactions (id, itemId, userId, amount, authBackend, timestamp) 
-		VALUES ($1, $2, $3, $4, $5, $6)`, uuid.New().String(), item.Id, user.Id, amount, authBackend, time.Now().Unix())
-	if err != nil {
-		err := tx.Rollback()
+	receipt.Balance = buyer.Credit
+	if !buyer.IsCashUser() {
+		receipt.Balance, err = users.ChangeCreditWithTransaction(ctx, buyer.Id, -receipt.Total, tx)
+		if errors.Is(err, users.ErrInsufficientCredit) {
+			return Receipt{}, ErrNotEnoughCredits
+		}
 		if err != nil {
-			return err
+			return Receipt{}, err
 		}
-		return err
 	}
-	return tx.Commit()
+	user.Credit = receipt.Balance
+	return receipt, nil
 }
@@ -0,0 +1,351 @@
+package transactions
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/events"
+	"github.com/Port39/go-drink/items"
+	"github.com/Port39/go-drink/migrations"
+	"github.com/Port39/go-drink/pricing"
+	"github.com/Port39/go-drink/testutils"
+	"github.com/Port39/go-drink/users"
+)
+
+var testBuyer = users.User{
+	Id:       "00000000-0000-0000-0000-000000000001",
+	Username: "buyer",
+	Email:    "buyer@godrink.test",
+	Role:     "user",
+	Credit:   1000,
+}
+
+var testMate = items.Item{
+	Id:     "00000000-0000-0000-0000-00000000000a",
+	Name:   "Mate",
+	Price:  150,
+	Amount: 10,
+}
+
+var testGranat = items.Item{
+	Id:     "00000000-0000-0000-0000-00000000000b",
+	Name:   "Club-Mate Granat",
+	Price:  200,
+	Amount: 2,
+}
+
+func setupShop(t *testing.T, db *sql.DB) {
+	t.Helper()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	testutils.FailOnError(users.AddUser(ctx, testBuyer, db), t)
+	testutils.FailOnError(users.VerifyCashUserExists(db), t)
+	for _, item := range []items.Item{testMate, testGranat} {
+		testutils.FailOnError(items.InsertNewItem(ctx, &item, db), t)
+	}
+}
+
+func expectStock(t *testing.T, db *sql.DB, item items.Item, amount int) {
+	t.Helper()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	retrieved, err := items.GetItemById(ctx, item.Id, db)
+	testutils.FailOnError(err, t)
+	if retrieved.Amount != amount {
+		t.Fatalf("expected %d of %s in stock, got %d", amount, item.Name, retrieved.Amount)
+	}
+}
+
+func expectCredit(t *testing.T, db *sql.DB, userId string, credit int) {
+	t.Helper()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	retrieved, err := users.GetUserForId(ctx, userId, db)
+	testutils.FailOnError(err, t)
+	if retrieved.Credit != credit {
+		t.Fatalf("expected a credit of %d, got %d", credit, retrieved.Credit)
+	}
+}
+
+func TestMakeTransaction(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	buyer := testBuyer
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{
+		{ItemId: testMate.Id, Amount: 2},
+		{ItemId: testGranat.Id, Amount: 1},
+		{ItemId: testMate.Id, Amount: 1},
+	}, "password", db)
+	testutils.FailOnError(err, t)
+
+	// lines for the same item are merged
+	testutils.ExpectEqual(len(receipt.Lines), 2, t)
+	testutils.ExpectEqual(receipt.Lines[0].Amount, 3, t)
+	testutils.ExpectEqual(receipt.Lines[0].Total, 450, t)
+	testutils.ExpectEqual(receipt.Lines[1].Name, testGranat.Name, t)
+	testutils.ExpectEqual(receipt.Total, 650, t)
+	testutils.ExpectEqual(receipt.Balance, 350, t)
+	testutils.ExpectEqual(buyer.Credit, 350, t)
+
+	expectCredit(t, db, testBuyer.Id, 350)
+	expectStock(t, db, testMate, 7)
+	expectStock(t, db, testGranat, 1)
+
+	transactions, err := GetTransactionsSince(ctx, 0, time.Now().Unix()+1, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(transactions), 2, t)
+}
+
+func TestMakeTransaction_KeepsPrice(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	buyer := testBuyer
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 2}}, "password", db)
+	testutils.FailOnError(err, t)
+
+	changed := testMate
+	changed.Name = "Mate (0.33l)"
+	changed.Price = 120
+	testutils.FailOnError(items.UpdateItem(ctx, &changed, db), t)
+
+	// the purchase is not affected by changes to the item
+	purchase, err := GetTransactionById(ctx, receipt.Lines[0].TransactionId, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(purchase.ItemName, testMate.Name, t)
+	testutils.ExpectEqual(purchase.UnitPrice, testMate.Price, t)
+	testutils.ExpectEqual(purchase.Total, 2*testMate.Price, t)
+
+	// and neither is its refund
+	refund, err := RefundTransaction(ctx, purchase.Id, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(refund.ItemName, testMate.Name, t)
+	testutils.ExpectEqual(refund.UnitPrice, testMate.Price, t)
+	testutils.ExpectEqual(refund.Total, -2*testMate.Price, t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit)
+}
+
+func TestMakeTransaction_IsAtomic(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	// the second line exceeds the stock, so nothing must be bought
+	buyer := testBuyer
+	_, err := MakeTransaction(ctx, &buyer, []CartLine{
+		{ItemId: testMate.Id, Amount: 1},
+		{ItemId: testGranat.Id, Amount: 3},
+	}, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNotEnoughStock), t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit)
+	expectStock(t, db, testMate, testMate.Amount)
+	expectStock(t, db, testGranat, testGranat.Amount)
+
+	// the total exceeds the credit, even though every single line would be affordable
+	_, err = MakeTransaction(ctx, &buyer, []CartLine{
+		{ItemId: testMate.Id, Amount: 6},
+		{ItemId: testGranat.Id, Amount: 1},
+	}, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNotEnoughCredits), t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit)
+	expectStock(t, db, testMate, testMate.Amount)
+
+	_, err = MakeTransaction(ctx, &buyer, []CartLine{
+		{ItemId: "00000000-0000-0000-0000-0000000000ff", Amount: 1},
+	}, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchItem), t)
+
+	_, err = MakeTransaction(ctx, &buyer, []CartLine{}, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrEmptyCart), t)
+}
+
+func TestMakeTransaction_CashUser(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	cashUser, err := users.GetUserForId(ctx, users.CashUserId, db)
+	testutils.FailOnError(err, t)
+	_, err = MakeTransaction(ctx, &cashUser, []CartLine{{ItemId: testMate.Id, Amount: 1}}, "cash", db)
+	testutils.FailOnError(err, t)
+
+	// the credit of the cash user is never reduced
+	expectCredit(t, db, users.CashUserId, cashUser.Credit)
+	expectStock(t, db, testMate, testMate.Amount-1)
+}
+
+func TestMakeTransaction_ArchivedItem(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	buyer := testBuyer
+	_, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 1}}, "password", db)
+	testutils.FailOnError(err, t)
+	// items which have been sold can only be archived
+	err = items.DeleteItem(ctx, testMate.Id, db)
+	testutils.ExpectSuccess(errors.Is(err, items.ErrItemSold), t)
+	testutils.FailOnError(items.SetArchived(ctx, testMate.Id, true, db), t)
+
+	_, err = MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 1}}, "password", db)
+	testutils.ExpectSuccess(errors.Is(err, ErrItemArchived), t)
+	expectStock(t, db, testMate, testMate.Amount-1)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit-testMate.Price)
+}
+
+func TestMakeTransaction_LowStock(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+	published := make(chan events.Event, 10)
+	events.Subscribe(func(event events.Event) {
+		published <- event
+	})
+
+	mate := testMate
+	mate.MinStock = 8
+	testutils.FailOnError(items.UpdateItem(ctx, &mate, db), t)
+	buyer := testBuyer
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: mate.Id, Amount: 2}}, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(receipt.lowStock), 0, t)
+
+	receipt, err = MakeTransaction(ctx, &buyer, []CartLine{{ItemId: mate.Id, Amount: 1}}, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(receipt.lowStock), 1, t)
+	expected := events.LowStock{ItemId: mate.Id, ItemName: mate.Name, Amount: 7, MinStock: 8}
+	testutils.ExpectEqual(receipt.lowStock[0], expected, t)
+	select {
+	case event := <-published:
+		testutils.ExpectEqual(event.Type, events.TypeLowStock, t)
+		testutils.ExpectEqual(event.Data, any(expected), t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no low stock event has been published")
+	}
+
+	// the threshold has already been crossed
+	receipt, err = MakeTransaction(ctx, &buyer, []CartLine{{ItemId: mate.Id, Amount: 1}}, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(receipt.lowStock), 0, t)
+
+	lowStock, err := items.GetLowStockItems(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(lowStock), 1, t)
+	testutils.ExpectEqual(lowStock[0].Id, mate.Id, t)
+}
+
+func TestMakeTransaction_PricingRule(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	setupShop(t, db)
+
+	bundle := pricing.Rule{
+		Id:     "00000000-0000-0000-0000-0000000000e1",
+		Name:   "Buy 2, get one free",
+		Type:   pricing.TypeBundle,
+		Value:  2,
+		ItemId: testMate.Id,
+	}
+	testutils.FailOnError(pricing.InsertNewRule(ctx, bundle, db), t)
+	buyer := testBuyer
+	receipt, err := MakeTransaction(ctx, &buyer, []CartLine{
+		{ItemId: testMate.Id, Amount: 3},
+		{ItemId: testGranat.Id, Amount: 1},
+	}, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(receipt.Lines[0].Total, 300, t)
+	testutils.ExpectEqual(receipt.Lines[0].PricingRule, bundle.Name, t)
+	testutils.ExpectEqual(receipt.Lines[1].Total, 200, t)
+	testutils.ExpectEqual(receipt.Lines[1].PricingRule, "", t)
+	testutils.ExpectEqual(receipt.Total, 500, t)
+	expectCredit(t, db, buyer.Id, testBuyer.Credit-500)
+
+	purchase, err := GetTransactionById(ctx, receipt.Lines[0].TransactionId, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(purchase.PricingRuleId, bundle.Id, t)
+
+	// the refund is calculated from the recorded total, even if the rule is gone
+	testutils.FailOnError(pricing.DeleteRule(ctx, bundle.Id, db), t)
+	refund, err := RefundTransaction(ctx, purchase.Id, "password", db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(refund.Money, 300, t)
+	testutils.ExpectEqual(refund.PricingRuleId, bundle.Id, t)
+}
+
+// TestMakeTransaction_Concurrency
+// runs many purchases in parallel, and verifies that neither credit nor stock drift
+func TestMakeTransaction_Concurrency(t *testing.T) {
+	db := testutils.GetFileDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+	testutils.FailOnError(migrations.Up(ctx, db, migrations.Sqlite), t)
+	setupShop(t, db)
+
+	secondBuyer := testBuyer
+	secondBuyer.Id = "00000000-0000-0000-0000-000000000002"
+	secondBuyer.Username = "secondBuyer"
+	testutils.FailOnError(users.AddUser(ctx, secondBuyer, db), t)
+	mate := testMate
+	mate.MinStock = 5
+	testutils.FailOnError(items.UpdateItem(ctx, &mate, db), t)
+
+	// each buyer can afford at most 6 Mate, and there are only 10 in stock, so most purchases must fail
+	const purchases = 300
+	buyerIds := []string{testBuyer.Id, secondBuyer.Id, users.CashUserId}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	bought := make(map[string]int)
+	lowStock := 0
+	for i := range purchases {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buyer := users.User{Id: buyerIds[i%len(buyerIds)]}
+			receipt, err := MakeTransaction(ctx, &buyer, []CartLine{{ItemId: testMate.Id, Amount: 1}}, "password", db)
+			if err != nil && !errors.Is(err, ErrNotEnoughCredits) && !errors.Is(err, ErrNotEnoughStock) {
+				t.Error(err)
+				return
+			}
+			if err == nil {
+				mutex.Lock()
+				bought[buyer.Id]++
+				lowStock += len(receipt.lowStock)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	totalBought := bought[testBuyer.Id] + bought[secondBuyer.Id] + bought[users.CashUserId]
+	testutils.ExpectEqual(totalBought, testMate.Amount, t)
+	expectStock(t, db, testMate, 0)
+	// exactly one purchase crossed the minimum stock
+	testutils.ExpectEqual(lowStock, 1, t)
+	expectCredit(t, db, testBuyer.Id, testBuyer.Credit-bought[testBuyer.Id]*testMate.Price)
+	expectCredit(t, db, secondBuyer.Id, secondBuyer.Credit-bought[secondBuyer.Id]*testMate.Price)
+
+	transactions, err := GetTransactionsSince(ctx, 0, time.Now().Unix()+1, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(transactions), totalBought, t)
+}
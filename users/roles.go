@@ -0,0 +1,267 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+)
+
+const (
+	// PermissionItemsBuy allows buying items, returning empties and undoing your own purchases
+	PermissionItemsBuy = "items:buy"
+	// PermissionAccountOwn allows managing your own account, i.e. your credit, transactions and login methods
+	PermissionAccountOwn       = "account:own"
+	PermissionItemsWrite       = "items:write"
+	PermissionPricingWrite     = "pricing:write"
+	PermissionStockRead        = "stock:read"
+	PermissionStockRestock     = "stock:restock"
+	PermissionStockTake        = "stock:take"
+	PermissionUsersRead        = "users:read"
+	PermissionUsersWrite       = "users:write"
+	PermissionCreditAdjust     = "credit:adjust"
+	PermissionTransactionsRead = "transactions:read"
+	// PermissionTransactionsRefund allows refunding the purchases of any user
+	PermissionTransactionsRefund = "transactions:refund"
+	PermissionReportsRead        = "reports:read"
+	PermissionRolesWrite         = "roles:write"
+)
+
+// Permissions
+// All permissions a role can be given
+var Permissions = []string{
+	PermissionItemsBuy,
+	PermissionAccountOwn,
+	PermissionItemsWrite,
+	PermissionPricingWrite,
+	PermissionStockRead,
+	PermissionStockRestock,
+	PermissionStockTake,
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionCreditAdjust,
+	PermissionTransactionsRead,
+	PermissionTransactionsRefund,
+	PermissionReportsRead,
+	PermissionRolesWrite,
+}
+
+const (
+	// RoleAdmin has every permission, including the ones added in the future, so it can't be changed
+	RoleAdmin = "admin"
+	// RoleUser is given to newly registered users, so it can't be deleted
+	RoleUser = "user"
+)
+
+var (
+	ErrNoSuchRole  = errors.New("no such role")
+	ErrRoleExists  = errors.New("a role with this name already exists")
+	ErrBuiltinRole = errors.New("the admin role can't be changed, and neither the admin nor the user role deleted")
+	ErrRoleInUse   = errors.New("the role is still assigned to users")
+)
+
+// Role
+// A named set of permissions
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// GetRoles
+// All roles ordered by their name, with their permissions in the order of Permissions
+func GetRoles(ctx context.Context, db *sql.DB) ([]Role, error) {
+	roles := make([]Role, 0)
+	result, err := db.QueryContext(ctx, `SELECT r.name, p.permission FROM roles r
+		LEFT JOIN role_permissions p ON p.role = r.name ORDER BY r.name`)
+	if err != nil {
+		return roles, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var name string
+		var permission sql.NullString
+		err = result.Scan(&name, &permission)
+		if err != nil {
+			return roles, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, Role{Name: name, Permissions: make([]string, 0)})
+		}
+		if permission.Valid {
+			roles[len(roles)-1].Permissions = append(roles[len(roles)-1].Permissions, permission.String)
+		}
+	}
+	for i := range roles {
+		setPermissions(&roles[i], roles[i].Permissions)
+	}
+	return roles, result.Err()
+}
+
+func GetRole(ctx context.Context, name string, db *sql.DB) (Role, error) {
+	result, err := db.QueryContext(ctx, `SELECT r.name, p.permission FROM roles r
+		LEFT JOIN role_permissions p ON p.role = r.name WHERE r.name = $1`, name)
+	if err != nil {
+		return Role{}, err
+	}
+	defer result.Close()
+	var role Role
+	permissions := make([]string, 0)
+	for result.Next() {
+		var permission sql.NullString
+		err = result.Scan(&role.Name, &permission)
+		if err != nil {
+			return Role{}, err
+		}
+		if permission.Valid {
+			permissions = append(permissions, permission.String)
+		}
+	}
+	if err = result.Err(); err != nil {
+		return Role{}, err
+	}
+	if role.Name == "" {
+		return Role{}, ErrNoSuchRole
+	}
+	setPermissions(&role, permissions)
+	return role, nil
+}
+
+// setPermissions
+// Order the permissions like Permissions, dropping unknown ones. Admins get all permissions.
+func setPermissions(role *Role, permissions []string) {
+	role.Permissions = make([]string, 0, len(permissions))
+	for _, permission := range Permissions {
+		if role.Name == RoleAdmin || slices.Contains(permissions, permission) {
+			role.Permissions = append(role.Permissions, permission)
+		}
+	}
+}
+
+// HasPermission
+// Check whether users with the role have the permission. Permissions are looked up on every check, so changes to a
+// role apply to existing sessions immediately.
+func HasPermission(ctx context.Context, role, permission string, db *sql.DB) (bool, error) {
+	if role == RoleAdmin {
+		return true, nil
+	}
+	var found int
+	err := db.QueryRowContext(ctx, `SELECT 1 FROM role_permissions WHERE role = $1 AND permission = $2`,
+		role, permission).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func AddRole(ctx context.Context, role Role, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = addRoleWithTransaction(ctx, role, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func addRoleWithTransaction(ctx context.Context, role Role, tx *sql.Tx) error {
+	var existing string
+	err := tx.QueryRowContext(ctx, `SELECT name FROM roles WHERE name = $1`, role.Name).Scan(&existing)
+	if err == nil {
+		return ErrRoleExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO roles (name) VALUES ($1)`, role.Name)
+	if err != nil {
+		return err
+	}
+	return insertPermissionsWithTransaction(ctx, role, tx)
+}
+
+func insertPermissionsWithTransaction(ctx context.Context, role Role, tx *sql.Tx) error {
+	for _, permission := range role.Permissions {
+		_, err := tx.ExecContext(ctx, `INSERT INTO role_permissions (role, permission) VALUES ($1, $2)`,
+			role.Name, permission)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateRole
+// Replace the permissions of the role
+func UpdateRole(ctx context.Context, role Role, db *sql.DB) error {
+	if role.Name == RoleAdmin {
+		return ErrBuiltinRole
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = updateRoleWithTransaction(ctx, role, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func updateRoleWithTransaction(ctx context.Context, role Role, tx *sql.Tx) error {
+	var existing string
+	err := tx.QueryRowContext(ctx, `SELECT name FROM roles WHERE name = $1`, role.Name).Scan(&existing)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoSuchRole
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role = $1`, role.Name)
+	if err != nil {
+		return err
+	}
+	return insertPermissionsWithTransaction(ctx, role, tx)
+}
+
+// DeleteRole
+// Delete a role which is not assigned to any user anymore
+func DeleteRole(ctx context.Context, name string, db *sql.DB) error {
+	if name == RoleAdmin || name == RoleUser {
+		return ErrBuiltinRole
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = deleteRoleWithTransaction(ctx, name, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func deleteRoleWithTransaction(ctx context.Context, name string, tx *sql.Tx) error {
+	var users int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE role = $1`, name).Scan(&users)
+	if err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrRoleInUse
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM roles WHERE name = $1`, name)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNoSuchRole
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role = $1`, name)
+	return err
+}
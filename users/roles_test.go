@@ -0,0 +1,81 @@
+package users
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestHasPermission(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	check := func(role, permission string) bool {
+		allowed, err := HasPermission(ctx, role, permission, db)
+		testutils.FailOnError(err, t)
+		return allowed
+	}
+
+	// Admins are allowed to do anything
+	testutils.ExpectSuccess(check(RoleAdmin, PermissionRolesWrite), t)
+	testutils.ExpectSuccess(check(RoleAdmin, "doesnotexist"), t)
+
+	// Everyone else only has the permissions of their role
+	testutils.ExpectSuccess(check(RoleUser, PermissionItemsBuy), t)
+	testutils.ExpectFailure(check(RoleUser, PermissionStockRestock), t)
+	testutils.ExpectSuccess(check("restocker", PermissionItemsBuy), t)
+	testutils.ExpectSuccess(check("restocker", PermissionStockRestock), t)
+	testutils.ExpectFailure(check("restocker", PermissionItemsWrite), t)
+	testutils.ExpectSuccess(check("treasurer", PermissionCreditAdjust), t)
+	testutils.ExpectFailure(check("treasurer", PermissionUsersWrite), t)
+	testutils.ExpectFailure(check("doesnotexist", PermissionItemsBuy), t)
+}
+
+func TestRoles(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	roles, err := GetRoles(ctx, db)
+	testutils.FailOnError(err, t)
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	testutils.ExpectSuccess(slices.Equal(names, []string{"admin", "restocker", "treasurer", "user"}), t)
+	testutils.ExpectSuccess(slices.Equal(roles[0].Permissions, Permissions), t)
+
+	// permissions are returned in the order of Permissions
+	bartender := Role{Name: "bartender", Permissions: []string{PermissionStockRestock, PermissionItemsBuy}}
+	testutils.FailOnError(AddRole(ctx, bartender, db), t)
+	testutils.ExpectSuccess(errors.Is(AddRole(ctx, bartender, db), ErrRoleExists), t)
+	retrieved, err := GetRole(ctx, bartender.Name, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(slices.Equal(retrieved.Permissions, []string{PermissionItemsBuy, PermissionStockRestock}), t)
+
+	bartender.Permissions = []string{}
+	testutils.FailOnError(UpdateRole(ctx, bartender, db), t)
+	retrieved, err = GetRole(ctx, bartender.Name, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(retrieved.Permissions), 0, t)
+	testutils.ExpectSuccess(errors.Is(UpdateRole(ctx, Role{Name: RoleAdmin}, db), ErrBuiltinRole), t)
+	testutils.ExpectSuccess(errors.Is(UpdateRole(ctx, Role{Name: "doesnotexist"}, db), ErrNoSuchRole), t)
+
+	// roles can only be deleted if no one has them anymore
+	user := testUser1
+	user.Role = bartender.Name
+	testutils.FailOnError(AddUser(ctx, user, db), t)
+	testutils.ExpectSuccess(errors.Is(DeleteRole(ctx, bartender.Name, db), ErrRoleInUse), t)
+	user.Role = RoleUser
+	testutils.FailOnError(UpdateUserDetails(ctx, &user, db), t)
+	testutils.FailOnError(DeleteRole(ctx, bartender.Name, db), t)
+	_, err = GetRole(ctx, bartender.Name, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchRole), t)
+	testutils.ExpectSuccess(errors.Is(DeleteRole(ctx, bartender.Name, db), ErrNoSuchRole), t)
+	testutils.ExpectSuccess(errors.Is(DeleteRole(ctx, RoleUser, db), ErrBuiltinRole), t)
+}
@@ -7,6 +7,7 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/Port39/go-drink/mailing"
+	"github.com/Port39/go-drink/pagination"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/argon2"
 	"log"
@@ -14,15 +15,42 @@ import (
 	"time"
 )
 
+var (
+	ErrInsufficientCredit = errors.New("insufficient credit")
+	ErrNoSuchUser         = errors.New("no such user")
+	ErrUserDeactivated    = errors.New("the account has been deactivated")
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrEmailNotVerified   = errors.New("the email address has not been verified")
+	ErrNoSuchAuth         = errors.New("no matching authentication available")
+)
+
 const CashUserId = "00000000-0000-0000-0000-000000000000"
 const AdminUserId = "00000000-0000-0000-0000-000000000001"
 
+// User
+// Deactivated users are kept with their ledger, but can't log in anymore. Verified is set once the user confirmed
+// their email address with the token mailed to it, and reset whenever the address changes.
 type User struct {
-	Id       string `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Role     string `json:"role"`
-	Credit   int    `json:"credit"`
+	Id          string `json:"id"`
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	Verified    bool   `json:"verified"`
+	Role        string `json:"role"`
+	Credit      int    `json:"credit"`
+	Deactivated bool   `json:"deactivated"`
+}
+
+// columns which may be NULL in databases from before the migrations are read as their zero value
+const userColumns = "id, username, COALESCE(email, ''), verified, COALESCE(role, ''), COALESCE(credit, 0), deactivated"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row scanner) (User, error) {
+	var user User
+	err := row.Scan(&user.Id, &user.Username, &user.Email, &user.Verified, &user.Role, &user.Credit, &user.Deactivated)
+	return user, err
 }
 
 func (u *User) IsCashUser() bool {
@@ -77,17 +105,6 @@ func VerifyPasswordHash(hash []byte, pass string) bool {
 	return bytes.Equal(targetKey, actualKey)
 }
 
-func VerifyUsersTableExists(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (
-    		id VARCHAR (36) PRIMARY KEY,
-    		username VARCHAR (64) UNIQUE NOT NULL,
-    		email VARCHAR (64),
-    		role VARCHAR (16),
-    		credit INTEGER
-		)`)
-	return err
-}
-
 func VerifyCashUserExists(db *sql.DB) error {
 	_, err := db.Exec(`INSERT INTO users (id, username, email, role, credit) 
 	VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`, CashUserId, "CASH PAYMENTS", "cash@localhost", "user", 65535)
@@ -110,6 +127,11 @@ func VerifyAdminUserExists(db *sql.DB) error {
 	}
 
 	rows, err := db.Query("SELECT * FROM auth WHERE user_id = $1", AdminUserId)
+	if err != nil {
+		return err
+	}
+	// an open result keeps a read lock on sqlite databases, blocking all writes until it is closed
+	defer rows.Close()
 
 	if !rows.Next() {
 		password := uuid.New()
@@ -127,51 +149,40 @@ func VerifyAdminUserExists(db *sql.DB) error {
 	return err
 }
 
-func VerifyAuthTableExists(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS auth (
-    		user_id VARCHAR (36) NOT NULL,
-    		type VARCHAR (16) NOT NULL,
-    		data bytea,
-    		PRIMARY KEY (user_id, type)
-		)`)
-	return err
-}
-
-func VerifyPasswordResetTableExists(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS password_reset (
-    		user_id VARCHAR (36) UNIQUE NOT NULL,
-    		token VARCHAR (36) PRIMARY KEY,
-    		valid_until BIGINT
-		)`)
-	return err
+func GetUserForId(ctx context.Context, id string, db *sql.DB) (User, error) {
+	result, err := db.QueryContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
+	if err != nil {
+		return User{}, err
+	}
+	defer result.Close()
+	if !result.Next() {
+		return User{}, ErrNoSuchUser
+	}
+	return scanUser(result)
 }
 
-func GetUserForId(ctx context.Context, id string, db *sql.DB) (User, error) {
-	result, err := db.QueryContext(ctx, "SELECT id, username, email, role, credit FROM users WHERE id = $1", id)
+func GetUserForIdWithTransaction(ctx context.Context, id string, tx *sql.Tx) (User, error) {
+	result, err := tx.QueryContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
 	if err != nil {
 		return User{}, err
 	}
 	defer result.Close()
 	if !result.Next() {
-		return User{}, errors.New("no such user")
+		return User{}, ErrNoSuchUser
 	}
-	var user User
-	err = result.Scan(&user.Id, &user.Username, &user.Email, &user.Role, &user.Credit)
-	return user, err
+	return scanUser(result)
 }
 
 func GetUserForUsername(ctx context.Context, username string, db *sql.DB) (User, error) {
-	result, err := db.QueryContext(ctx, "SELECT id, username, email, role, credit FROM users WHERE username = $1", username)
+	result, err := db.QueryContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username)
 	if err != nil {
 		return User{}, err
 	}
 	defer result.Close()
 	if !result.Next() {
-		return User{}, errors.New("no such user")
+		return User{}, ErrNoSuchUser
 	}
-	var user User
-	err = result.Scan(&user.Id, &user.Username, &user.Email, &user.Role, &user.Credit)
-	return user, err
+	return scanUser(result)
 }
 
 func GetUserForNFCToken(ctx context.Context, token []byte, db *sql.DB) (User, error) {
@@ -215,7 +226,7 @@ func GetUsernamesWithNoneAuth(ctx context.Context, db *sql.DB) ([]string, error)
 	names := make([]string, 0)
 	for _, userId := range userIds {
 		user, err := GetUserForId(ctx, userId, db)
-		if err != nil {
+		if err != nil || user.Deactivated {
 			continue
 		}
 		names = append(names, user.Username)
@@ -223,9 +234,54 @@ func GetUsernamesWithNoneAuth(ctx context.Context, db *sql.DB) ([]string, error)
 	return names, nil
 }
 
+// Sorts
+// The keys users can be sorted by, and their columns
+var Sorts = map[string]string{
+	"username": "username",
+	"role":     "COALESCE(role, '')",
+	"credit":   "COALESCE(credit, 0)",
+}
+
+// GetUsers
+// Get a page of all users, optionally only those with the given role. If there are more users, a cursor pointing to
+// the next page is returned as well.
+func GetUsers(ctx context.Context, role string, page pagination.Page, db *sql.DB) ([]User, *pagination.Cursor, error) {
+	users := make([]User, 0)
+	var query pagination.Query
+	if role != "" {
+		query.Where("role = " + query.Arg(role))
+	}
+	statement, args := query.Build(`SELECT `+userColumns+` FROM users`, "id", page)
+	result, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		user, err := scanUser(result)
+		if err != nil {
+			return nil, nil, err
+		}
+		users = append(users, user)
+	}
+	if err = result.Err(); err != nil {
+		return nil, nil, err
+	}
+	users, next := pagination.Trim(users, page, func(user User) (any, string) {
+		switch page.Sort {
+		case "role":
+			return user.Role, user.Id
+		case "credit":
+			return user.Credit, user.Id
+		}
+		return user.Username, user.Id
+	})
+	return users, next, nil
+}
+
 func AddUser(ctx context.Context, user User, db *sql.DB) error {
-	_, err := db.ExecContext(ctx, "INSERT INTO users (id, username, email, role, credit) VALUES ($1, $2, $3, $4, $5)",
-		user.Id, user.Username, user.Email, user.Role, user.Credit)
+	_, err := db.ExecContext(ctx, "INSERT INTO users (id, username, email, verified, role, credit) VALUES ($1, $2, $3, $4, $5, $6)",
+		user.Id, user.Username, user.Email, user.Verified, user.Role, user.Credit)
 	return err
 }
 
@@ -248,7 +304,7 @@ func GetAuthForUser(ctx context.Context, id, authType string, db *sql.DB) (Authe
 	}
 	defer result.Close()
 	if !result.Next() {
-		return AuthenticationData{}, errors.New("no matching authentication available")
+		return AuthenticationData{}, ErrNoSuchAuth
 	}
 	var auth AuthenticationData
 	err = result.Scan(&auth.User, &auth.Type, &auth.Data)
@@ -257,14 +313,13 @@ func GetAuthForUser(ctx context.Context, id, authType string, db *sql.DB) (Authe
 
 func GetAllUsers(ctx context.Context, db *sql.DB) ([]User, error) {
 	users := make([]User, 0)
-	result, err := db.QueryContext(ctx, "SELECT id, username, email, role, credit FROM users")
+	result, err := db.QueryContext(ctx, "SELECT "+userColumns+" FROM users")
 	if err != nil {
 		return users, err
 	}
 	defer result.Close()
 	for result.Next() {
-		var user User
-		err = result.Scan(&user.Id, &user.Username, &user.Email, &user.Role, &user.Credit)
+		user, err := scanUser(result)
 		if err != nil {
 			log.Println("Error reading results:", err)
 		}
@@ -273,23 +328,84 @@ func GetAllUsers(ctx context.Context, db *sql.DB) ([]User, error) {
 	return users, nil
 }
 
-func UpdateUserWithTransaction(ctx context.Context, user *User, tx *sql.Tx) error {
-	_, err := tx.ExecContext(ctx, `UPDATE users SET username = $1, email = $2, role = $3, credit = $4 WHERE id = $5`,
-		user.Username, user.Email, user.Role, user.Credit, user.Id)
+// UpdateUserDetails
+// Change the username, email and role of the user, leaving the credit untouched. A changed email has to be verified
+// again, and replaces any email change the user has not confirmed yet.
+func UpdateUserDetails(ctx context.Context, user *User, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = updateUserDetailsWithTransaction(ctx, user, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func updateUserDetailsWithTransaction(ctx context.Context, user *User, tx *sql.Tx) error {
+	var existing string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE username = $1 AND id <> $2`, user.Username, user.Id).
+		Scan(&existing)
+	if err == nil {
+		return ErrUsernameTaken
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	var email string
+	err = tx.QueryRowContext(ctx, `SELECT email, verified FROM users WHERE id = $1`, user.Id).Scan(&email, &user.Verified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoSuchUser
+	}
+	if err != nil {
+		return err
+	}
+	user.Verified = user.Verified && email == user.Email
+	_, err = tx.ExecContext(ctx, `UPDATE users SET username = $1, email = $2, verified = $3, role = $4 WHERE id = $5`,
+		user.Username, user.Email, user.Verified, user.Role, user.Id)
+	if err != nil || email == user.Email {
+		return err
+	}
+	// pending tokens must not restore an address or change the email set here
+	_, err = tx.ExecContext(ctx, `DELETE FROM email_verification WHERE user_id = $1`, user.Id)
 	return err
 }
 
-func UpdateUser(ctx context.Context, user *User, db *sql.DB) error {
-	_, err := db.ExecContext(ctx, `UPDATE users SET username = $1, email = $2, role = $3, credit = $4 WHERE id = $5`,
-		user.Username, user.Email, user.Role, user.Credit, user.Id)
+// SetDeactivated
+// Deactivate a user, or reactivate a deactivated one
+func SetDeactivated(ctx context.Context, id string, deactivated bool, db *sql.DB) error {
+	result, err := db.ExecContext(ctx, `UPDATE users SET deactivated = $1 WHERE id = $2`, deactivated, id)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err == nil && updated == 0 {
+		return ErrNoSuchUser
+	}
 	return err
 }
 
-func CheckRole(actual, target string) bool {
-	if actual == "admin" || actual == target {
-		return true
+// ChangeCreditWithTransaction
+// Atomically add diff (which may be negative) to the credit of the user, and return the resulting credit.
+// The check for a sufficient credit is evaluated by the database, so concurrent changes can't lose updates or result
+// in a negative balance.
+func ChangeCreditWithTransaction(ctx context.Context, userId string, diff int, tx *sql.Tx) (int, error) {
+	result, err := tx.QueryContext(ctx, `UPDATE users SET credit = credit + $1 WHERE id = $2 AND credit + $1 >= 0 RETURNING credit`,
+		diff, userId)
+	if err != nil {
+		return 0, err
 	}
-	return false
+	defer result.Close()
+	if !result.Next() {
+		if err = result.Err(); err != nil {
+			return 0, err
+		}
+		return 0, ErrInsufficientCredit
+	}
+	var credit int
+	err = result.Scan(&credit)
+	return credit, err
 }
 
 func addPasswordResetToken(ctx context.Context, user *User, db *sql.DB) (PasswordResetToken, error) {
@@ -305,7 +421,10 @@ func addPasswordResetToken(ctx context.Context, user *User, db *sql.DB) (Passwor
 	return token, nil
 }
 
-func SendPasswordResetMail(username string, db *sql.DB) error {
+// SendPasswordResetMail
+// Mail a password reset token to the user. If verifiedOnly is set, unverified addresses don't get the mail, as they
+// might belong to someone else.
+func SendPasswordResetMail(username string, verifiedOnly bool, db *sql.DB) error {
 	ctx := context.Background()
 	user, err := GetUserForUsername(ctx, username, db)
 	if err != nil {
@@ -314,6 +433,9 @@ func SendPasswordResetMail(username string, db *sql.DB) error {
 	if user.Id == CashUserId {
 		return nil
 	}
+	if verifiedOnly && !user.Verified {
+		return ErrEmailNotVerified
+	}
 	token, err := addPasswordResetToken(ctx, &user, db)
 	if err != nil {
 		return err
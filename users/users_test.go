@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
+	"github.com/Port39/go-drink/pagination"
 	"github.com/Port39/go-drink/testutils"
 	"github.com/google/uuid"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -94,13 +97,11 @@ func TestPassword(t *testing.T) {
 }
 
 func TestCashUser(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyUsersTableExists(db), t)
-
 	// In an empty database, no cash user should be available
 	_, err := GetUserForId(ctx, CashUserId, db)
 	if err == nil || err.Error() != "no such user" {
@@ -118,13 +119,11 @@ func TestCashUser(t *testing.T) {
 }
 
 func TestAddUser(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyUsersTableExists(db), t)
-
 	// Verify that the user hasn't been added before
 	_, err := GetUserForId(ctx, testUser1.Id, db)
 	if err == nil || err.Error() != "no such user" {
@@ -167,14 +166,78 @@ func TestAddUser(t *testing.T) {
 	testutils.ExpectSuccess(allUsers[1] == testUser2, t)
 }
 
+func TestGetUsers(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
+	testutils.FailOnError(AddUser(ctx, testUser2, db), t)
+	testutils.FailOnError(VerifyCashUserExists(db), t)
+
+	// both test users have the same credit, so they are ordered by id
+	page, err := pagination.FromQuery(url.Values{"sort": {"credit"}, "limit": {"2"}}, Sorts, "username")
+	testutils.FailOnError(err, t)
+	list, next, err := GetUsers(ctx, "", page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(list), 2, t)
+	testutils.ExpectEqual(list[0].Id, testUser1.Id, t)
+	testutils.ExpectEqual(list[1].Id, testUser2.Id, t)
+	testutils.ExpectSuccess(next != nil, t)
+
+	page, err = pagination.FromQuery(url.Values{"sort": {"credit"}, "cursor": {next.String()}}, Sorts, "username")
+	testutils.FailOnError(err, t)
+	list, next, err = GetUsers(ctx, "", page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(list), 1, t)
+	testutils.ExpectEqual(list[0].Id, CashUserId, t)
+	testutils.ExpectSuccess(next == nil, t)
+
+	page, err = pagination.FromQuery(url.Values{}, Sorts, "username")
+	testutils.FailOnError(err, t)
+	list, _, err = GetUsers(ctx, "admin", page, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(len(list), 0, t)
+}
+
+func TestGetUsers_NullSortValues(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	// users from before the migrations may lack a role and credit
+	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
+	testutils.FailOnError(AddUser(ctx, testUser2, db), t)
+	_, err := db.ExecContext(ctx, `UPDATE users SET role = NULL, credit = NULL WHERE id = $1`, testUser2.Id)
+	testutils.FailOnError(err, t)
+
+	for _, sort := range []string{"role", "credit", "-role", "-credit"} {
+		page, err := pagination.FromQuery(url.Values{"sort": {sort}, "limit": {"1"}}, Sorts, "username")
+		testutils.FailOnError(err, t)
+		seen := 0
+		for {
+			list, next, err := GetUsers(ctx, "", page, db)
+			testutils.FailOnError(err, t)
+			seen += len(list)
+			if next == nil {
+				break
+			}
+			page, err = pagination.FromQuery(url.Values{"sort": {sort}, "limit": {"1"}, "cursor": {next.String()}},
+				Sorts, "username")
+			testutils.FailOnError(err, t)
+		}
+		testutils.ExpectEqual(seen, 2, t)
+	}
+}
+
 func TestGetUserForNFCToken(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyUsersTableExists(db), t)
-	testutils.FailOnError(VerifyAuthTableExists(db), t)
 	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
 
 	// No authentication data was added, so this should fail
@@ -189,13 +252,11 @@ func TestGetUserForNFCToken(t *testing.T) {
 }
 
 func TestGetUsernamesWithNoneAuth(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyUsersTableExists(db), t)
-	testutils.FailOnError(VerifyAuthTableExists(db), t)
 	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
 
 	usernames, err := GetUsernamesWithNoneAuth(ctx, db)
@@ -214,14 +275,39 @@ func TestGetUsernamesWithNoneAuth(t *testing.T) {
 	testutils.ExpectSuccess(usernames[0] == testUser1.Username, t)
 }
 
+func TestSetDeactivated(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
+	testutils.FailOnError(AddAuthentication(ctx, AuthenticationData{User: testUser1.Id, Type: "none"}, db), t)
+
+	testutils.FailOnError(SetDeactivated(ctx, testUser1.Id, true, db), t)
+	retrievedUser, err := GetUserForId(ctx, testUser1.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(retrievedUser.Deactivated, t)
+	// deactivated users can't log in, so they are not offered for logging in without authentication
+	usernames, err := GetUsernamesWithNoneAuth(ctx, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(len(usernames) == 0, t)
+
+	testutils.FailOnError(SetDeactivated(ctx, testUser1.Id, false, db), t)
+	retrievedUser, err = GetUserForId(ctx, testUser1.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(retrievedUser == testUser1, t)
+
+	err = SetDeactivated(ctx, testUser2.Id, true, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchUser), t)
+}
+
 func TestAddAuthenticationWithTransaction(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyUsersTableExists(db), t)
-	testutils.FailOnError(VerifyAuthTableExists(db), t)
 	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
 
 	tx, err := db.Begin()
@@ -245,13 +331,11 @@ func TestAddAuthenticationWithTransaction(t *testing.T) {
 }
 
 func TestGetAuthForUser(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyAuthTableExists(db), t)
-
 	_, err := GetAuthForUser(ctx, testUser1NFCAuth.User, testUser1NFCAuth.Type, db)
 	testutils.ExpectError(err, t)
 
@@ -267,60 +351,80 @@ func TestGetAuthForUser(t *testing.T) {
 	testutils.ExpectError(err, t)
 }
 
-func TestUpdateUser(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+func TestUpdateUserDetails(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyUsersTableExists(db), t)
 	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
+	testutils.FailOnError(AddUser(ctx, testUser2, db), t)
 
-	newUsername := "UpdatedUsername"
-	testUser1.Username = newUsername
-	testutils.FailOnError(UpdateUser(ctx, &testUser1, db), t)
-
+	// the credit is not changed, even if the given user is outdated
+	updated := testUser1
+	updated.Username, updated.Email, updated.Role, updated.Credit = "renamed", "renamed@godrink.test", "admin", 0
+	testutils.FailOnError(UpdateUserDetails(ctx, &updated, db), t)
 	retrievedUser, err := GetUserForId(ctx, testUser1.Id, db)
 	testutils.FailOnError(err, t)
-	testutils.ExpectSuccess(retrievedUser.Username == newUsername, t)
+	updated.Credit = testUser1.Credit
+	testutils.ExpectSuccess(retrievedUser == updated, t)
+
+	updated.Username = testUser2.Username
+	err = UpdateUserDetails(ctx, &updated, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrUsernameTaken), t)
+	updated = testUser2
+	updated.Id = uuid.New().String()
+	err = UpdateUserDetails(ctx, &updated, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrUsernameTaken), t)
+	updated.Username = "unknown"
+	err = UpdateUserDetails(ctx, &updated, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrNoSuchUser), t)
+}
 
-	newBalance := 65535
-	testUser1.Credit = newBalance
-	tx, err := db.Begin()
+func TestChangeCreditWithTransaction(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	testutils.FailOnError(AddUser(ctx, testUser2, db), t)
+
+	tx, err := db.BeginTx(ctx, nil)
 	testutils.FailOnError(err, t)
-	testutils.FailOnError(UpdateUserWithTransaction(ctx, &testUser1, tx), t)
-	testutils.FailOnError(tx.Rollback(), t)
+	credit, err := ChangeCreditWithTransaction(ctx, testUser2.Id, -1, tx)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(credit, testUser2.Credit-1, t)
 
-	retrievedUser, err = GetUserForId(ctx, testUser1.Id, db)
+	// the credit must never become negative
+	_, err = ChangeCreditWithTransaction(ctx, testUser2.Id, -testUser2.Credit, tx)
+	testutils.ExpectSuccess(errors.Is(err, ErrInsufficientCredit), t)
+
+	credit, err = ChangeCreditWithTransaction(ctx, testUser2.Id, 11, tx)
 	testutils.FailOnError(err, t)
-	testutils.ExpectFailure(retrievedUser.Credit == newBalance, t)
-}
+	testutils.ExpectEqual(credit, testUser2.Credit+10, t)
+	testutils.FailOnError(tx.Commit(), t)
 
-func TestCheckRole(t *testing.T) {
-	// Admins are allowed to do anything
-	testutils.ExpectSuccess(CheckRole("admin", "admin"), t)
-	testutils.ExpectSuccess(CheckRole("admin", "user"), t)
-	testutils.ExpectSuccess(CheckRole("admin", "doesnotexist"), t)
-
-	// Accessing stuff from your role is okay
-	testutils.ExpectSuccess(CheckRole("user", "user"), t)
-	testutils.ExpectSuccess(CheckRole("doesnotexist", "doesnotexist"), t)
-
-	// If you are not an admin, anything except your role is forbidden
-	testutils.ExpectFailure(CheckRole("user", "admin"), t)
-	testutils.ExpectFailure(CheckRole("user", "doesnotexist"), t)
-	testutils.ExpectFailure(CheckRole("doesnotexist", "admin"), t)
-	testutils.ExpectFailure(CheckRole("doesnotexist", "user"), t)
+	retrievedUser, err := GetUserForId(ctx, testUser2.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrievedUser.Credit, testUser2.Credit+10, t)
+
+	// changes are discarded together with the transaction
+	tx, err = db.BeginTx(ctx, nil)
+	testutils.FailOnError(err, t)
+	_, err = ChangeCreditWithTransaction(ctx, testUser2.Id, 65535, tx)
+	testutils.FailOnError(err, t)
+	testutils.FailOnError(tx.Rollback(), t)
+	retrievedUser, err = GetUserForId(ctx, testUser2.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrievedUser.Credit, testUser2.Credit+10, t)
 }
 
 func TestAddPasswordResetToken(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyPasswordResetTableExists(db), t)
-
 	token, err := addPasswordResetToken(ctx, &testUser1, db)
 	testutils.FailOnError(err, t)
 
@@ -347,38 +451,34 @@ func TestAddPasswordResetToken(t *testing.T) {
 }
 
 func TestSendPasswordResetMail(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyUsersTableExists(db), t)
 	testutils.FailOnError(VerifyCashUserExists(db), t)
-	testutils.FailOnError(VerifyPasswordResetTableExists(db), t)
 
 	cashuser, err := GetUserForId(ctx, CashUserId, db)
 	testutils.FailOnError(err, t)
 
-	err = SendPasswordResetMail(cashuser.Username, db)
+	err = SendPasswordResetMail(cashuser.Username, true, db)
 	// Sending password reset mails for the cash user should always fail silently.
 	testutils.FailOnError(err, t)
 
 	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
-	err = SendPasswordResetMail(testUser1.Username, db)
+	err = SendPasswordResetMail(testUser1.Username, true, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrEmailNotVerified), t)
+	err = SendPasswordResetMail(testUser1.Username, false, db)
 	// since mailing is not set up, this should fail
 	testutils.ExpectSuccess(strings.Contains(err.Error(), "mail: no address"), t)
 }
 
 func TestResetPassword(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyUsersTableExists(db), t)
-	testutils.FailOnError(VerifyAuthTableExists(db), t)
-	testutils.FailOnError(VerifyPasswordResetTableExists(db), t)
-
 	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
 	testutils.FailOnError(AddAuthentication(ctx, testUser1PasswordAuth, db), t)
 
@@ -402,13 +502,11 @@ func TestResetPassword(t *testing.T) {
 }
 
 func TestDeleteResetToken(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyPasswordResetTableExists(db), t)
-
 	token, err := addPasswordResetToken(ctx, &testUser1, db)
 	testutils.FailOnError(err, t)
 
@@ -419,13 +517,11 @@ func TestDeleteResetToken(t *testing.T) {
 }
 
 func TestDeleteResetTokenWithTransaction(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyPasswordResetTableExists(db), t)
-
 	token, err := addPasswordResetToken(ctx, &testUser1, db)
 	testutils.FailOnError(err, t)
 
@@ -458,13 +554,11 @@ func TestDeleteResetTokenWithTransaction(t *testing.T) {
 }
 
 func TestCleanExpiredResetTokens(t *testing.T) {
-	db := testutils.GetEmptyDb(t)
+	db := testutils.GetMigratedDb(t)
 	defer func() { testutils.FailOnError(db.Close(), t) }()
 	ctx, cancel := testutils.GetTestingContext(t)
 	defer cancel()
 
-	testutils.FailOnError(VerifyPasswordResetTableExists(db), t)
-
 	invalidToken, err := insertOutdatedPasswordResetToken(ctx, &testUser1, db)
 	testutils.FailOnError(err, t)
 	validToken, err := addPasswordResetToken(ctx, &testUser2, db)
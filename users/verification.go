@@ -0,0 +1,136 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Port39/go-drink/mailing"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidVerificationToken = errors.New("unknown token, it might have expired or the email has changed since")
+
+// EmailVerificationToken
+// A token mailed to an address, confirming it once it is sent back. If the token changes the email, the address
+// replaces the current one of the user when it is confirmed.
+type EmailVerificationToken struct {
+	UserId       string
+	Email        string
+	Token        string
+	ValidUntil   int64
+	ChangesEmail bool
+}
+
+// addEmailVerificationToken
+// Create a token for the current address of the user, replacing the previous one
+func addEmailVerificationToken(ctx context.Context, user *User, db *sql.DB) (EmailVerificationToken, error) {
+	return insertEmailVerificationToken(ctx, user.Id, user.Email, false, db)
+}
+
+// AddEmailChangeToken
+// Create a token for a new address of the user, replacing the previous one. The address is only used once the token
+// has been sent back, so users can't take on an address which isn't theirs.
+func AddEmailChangeToken(ctx context.Context, userId, email string, db *sql.DB) (EmailVerificationToken, error) {
+	return insertEmailVerificationToken(ctx, userId, email, true, db)
+}
+
+func insertEmailVerificationToken(ctx context.Context, userId, email string, changesEmail bool, db *sql.DB) (EmailVerificationToken, error) {
+	token := EmailVerificationToken{
+		UserId:       userId,
+		Email:        email,
+		Token:        uuid.New().String(),
+		ValidUntil:   time.Now().Add(24 * time.Hour).Unix(),
+		ChangesEmail: changesEmail,
+	}
+	_, err := db.ExecContext(ctx, `INSERT INTO email_verification (user_id, email, token, valid_until, changes_email)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET email = $2, token = $3, valid_until = $4, changes_email = $5`,
+		token.UserId, token.Email, token.Token, token.ValidUntil, token.ChangesEmail)
+	if err != nil {
+		return EmailVerificationToken{}, err
+	}
+	return token, nil
+}
+
+// GetPendingEmail
+// The new address of the user which is waiting to be confirmed, if there is one
+func GetPendingEmail(ctx context.Context, userId string, db *sql.DB) (string, error) {
+	var email string
+	err := db.QueryRowContext(ctx, `SELECT email FROM email_verification WHERE user_id = $1 AND changes_email
+		AND valid_until > $2`, userId, time.Now().Unix()).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return email, err
+}
+
+// SendEmailVerificationMail
+// Mail a verification token to the address of the user, unless there is nothing to verify
+func SendEmailVerificationMail(ctx context.Context, user *User, db *sql.DB) error {
+	if user.IsCashUser() || user.Email == "" || user.Verified {
+		return nil
+	}
+	token, err := addEmailVerificationToken(ctx, user, db)
+	if err != nil {
+		return err
+	}
+	return mailing.SendEmailVerificationMail(user.Username, user.Email, token.Token)
+}
+
+// VerifyEmail
+// Mark the address the token has been sent to as verified, if the user still has it. Tokens changing the email set
+// the verified address as the new one.
+func VerifyEmail(ctx context.Context, token string, db *sql.DB) (User, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	user, err := verifyEmailWithTransaction(ctx, token, tx)
+	if err != nil {
+		return User{}, errors.Join(err, tx.Rollback())
+	}
+	return user, tx.Commit()
+}
+
+func verifyEmailWithTransaction(ctx context.Context, token string, tx *sql.Tx) (User, error) {
+	var verification EmailVerificationToken
+	err := tx.QueryRowContext(ctx, `SELECT user_id, email, token, valid_until, changes_email FROM email_verification
+		WHERE token = $1`, token).Scan(&verification.UserId, &verification.Email, &verification.Token,
+		&verification.ValidUntil, &verification.ChangesEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if time.Now().Unix() > verification.ValidUntil {
+		return User{}, ErrInvalidVerificationToken
+	}
+	query := `UPDATE users SET verified = TRUE WHERE id = $1 AND email = $2`
+	if verification.ChangesEmail {
+		query = `UPDATE users SET email = $2, verified = TRUE WHERE id = $1`
+	}
+	result, err := tx.ExecContext(ctx, query, verification.UserId, verification.Email)
+	if err != nil {
+		return User{}, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+	if updated == 0 {
+		return User{}, ErrInvalidVerificationToken
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM email_verification WHERE user_id = $1`, verification.UserId)
+	if err != nil {
+		return User{}, err
+	}
+	return GetUserForIdWithTransaction(ctx, verification.UserId, tx)
+}
+
+func CleanExpiredVerificationTokens(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM email_verification WHERE valid_until <= $1`, time.Now().Unix())
+	return err
+}
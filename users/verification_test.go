@@ -0,0 +1,168 @@
+package users
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Port39/go-drink/testutils"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
+	token, err := addEmailVerificationToken(ctx, &testUser1, db)
+	testutils.FailOnError(err, t)
+
+	// a new token replaces the previous one
+	anotherToken, err := addEmailVerificationToken(ctx, &testUser1, db)
+	testutils.FailOnError(err, t)
+	_, err = VerifyEmail(ctx, token.Token, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidVerificationToken), t)
+
+	verified, err := VerifyEmail(ctx, anotherToken.Token, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectSuccess(verified.Verified, t)
+	_, err = VerifyEmail(ctx, anotherToken.Token, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidVerificationToken), t)
+
+	// keeping the address keeps it verified, changing it doesn't
+	testutils.FailOnError(UpdateUserDetails(ctx, &verified, db), t)
+	testutils.ExpectSuccess(verified.Verified, t)
+	token, err = addEmailVerificationToken(ctx, &verified, db)
+	testutils.FailOnError(err, t)
+	verified.Email = "changed@godrink.test"
+	testutils.FailOnError(UpdateUserDetails(ctx, &verified, db), t)
+	testutils.ExpectFailure(verified.Verified, t)
+	retrievedUser, err := GetUserForId(ctx, testUser1.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectFailure(retrievedUser.Verified, t)
+
+	// tokens only verify the address they have been sent to
+	_, err = VerifyEmail(ctx, token.Token, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidVerificationToken), t)
+}
+
+func TestSendEmailVerificationMail(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	// there is nothing to verify without an address
+	user := testUser1
+	user.Email = ""
+	testutils.FailOnError(SendEmailVerificationMail(ctx, &user, db), t)
+
+	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
+	err := SendEmailVerificationMail(ctx, &testUser1, db)
+	// since mailing is not set up, this should fail
+	testutils.ExpectSuccess(strings.Contains(err.Error(), "mail: no address"), t)
+}
+
+func TestCleanExpiredVerificationTokens(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	testutils.FailOnError(AddUser(ctx, testUser1, db), t)
+	testutils.FailOnError(AddUser(ctx, testUser2, db), t)
+	validToken, err := addEmailVerificationToken(ctx, &testUser1, db)
+	testutils.FailOnError(err, t)
+	invalidToken, err := addEmailVerificationToken(ctx, &testUser2, db)
+	testutils.FailOnError(err, t)
+	_, err = db.ExecContext(ctx, `UPDATE email_verification SET valid_until = $1 WHERE token = $2`,
+		time.Now().Add(-time.Hour).Unix(), invalidToken.Token)
+	testutils.FailOnError(err, t)
+
+	testutils.FailOnError(CleanExpiredVerificationTokens(ctx, db), t)
+
+	var remaining int
+	testutils.FailOnError(db.QueryRowContext(ctx, `SELECT COUNT(*) FROM email_verification`).Scan(&remaining), t)
+	testutils.ExpectEqual(remaining, 1, t)
+	_, err = VerifyEmail(ctx, validToken.Token, db)
+	testutils.FailOnError(err, t)
+}
+
+func TestEmailChange(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	user := testUser1
+	user.Verified = true
+	testutils.FailOnError(AddUser(ctx, user, db), t)
+	token, err := AddEmailChangeToken(ctx, user.Id, "new@godrink.test", db)
+	testutils.FailOnError(err, t)
+	pending, err := GetPendingEmail(ctx, user.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(pending, "new@godrink.test", t)
+
+	// the current address is kept until the new one has been confirmed
+	retrievedUser, err := GetUserForId(ctx, user.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrievedUser.Email, user.Email, t)
+	testutils.ExpectSuccess(retrievedUser.Verified, t)
+
+	changed, err := VerifyEmail(ctx, token.Token, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(changed.Email, "new@godrink.test", t)
+	testutils.ExpectSuccess(changed.Verified, t)
+	pending, err = GetPendingEmail(ctx, user.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(pending, "", t)
+
+	// verifying the current address replaces a pending change
+	_, err = AddEmailChangeToken(ctx, user.Id, "other@godrink.test", db)
+	testutils.FailOnError(err, t)
+	changed.Verified = false
+	_, err = addEmailVerificationToken(ctx, &changed, db)
+	testutils.FailOnError(err, t)
+	pending, err = GetPendingEmail(ctx, user.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(pending, "", t)
+}
+
+func TestEmailChange_ReplacedByAdmin(t *testing.T) {
+	db := testutils.GetMigratedDb(t)
+	defer func() { testutils.FailOnError(db.Close(), t) }()
+	ctx, cancel := testutils.GetTestingContext(t)
+	defer cancel()
+
+	user := testUser1
+	user.Verified = true
+	testutils.FailOnError(AddUser(ctx, user, db), t)
+	token, err := AddEmailChangeToken(ctx, user.Id, "new@godrink.test", db)
+	testutils.FailOnError(err, t)
+
+	// an admin sets another email before the pending change has been confirmed
+	updated := user
+	updated.Email = "admin-set@godrink.test"
+	testutils.FailOnError(UpdateUserDetails(ctx, &updated, db), t)
+	pending, err := GetPendingEmail(ctx, user.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(pending, "", t)
+
+	_, err = VerifyEmail(ctx, token.Token, db)
+	testutils.ExpectSuccess(errors.Is(err, ErrInvalidVerificationToken), t)
+	retrievedUser, err := GetUserForId(ctx, user.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(retrievedUser.Email, "admin-set@godrink.test", t)
+	testutils.ExpectFailure(retrievedUser.Verified, t)
+
+	// keeping the email leaves a pending change alone
+	_, err = AddEmailChangeToken(ctx, user.Id, "new@godrink.test", db)
+	testutils.FailOnError(err, t)
+	updated.Username = "renamed"
+	testutils.FailOnError(UpdateUserDetails(ctx, &updated, db), t)
+	pending, err = GetPendingEmail(ctx, user.Id, db)
+	testutils.FailOnError(err, t)
+	testutils.ExpectEqual(pending, "new@godrink.test", t)
+}